@@ -4,35 +4,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
 	"time"
 
 	"ghappauth/internal/auth"
-	"ghappauth/internal/types"
+	"ghappauth/internal/config"
 )
 
 
 func main() {
-	// Load configuration from environment variables
-	config := &types.GitHubAppConfig{
-		AppID:          os.Getenv("GITHUB_APP_ID"),
-		PrivateKey:     os.Getenv("GITHUB_PRIVATE_KEY"),
-		InstallationID: os.Getenv("GITHUB_INSTALLATION_ID"),
-	}
-
-	// Validate required environment variables
-	if config.AppID == "" {
-		log.Fatal("GITHUB_APP_ID environment variable is required")
-	}
-	if config.PrivateKey == "" {
-		log.Fatal("GITHUB_PRIVATE_KEY environment variable is required")
-	}
-	if config.InstallationID == "" {
-		log.Fatal("GITHUB_INSTALLATION_ID environment variable is required")
+	// Load configuration from GITHUB_APP_CONFIG and GITHUB_APP_* environment variables
+	appConfig, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
 	// Create GitHub App authentication instance
-	githubAuth, err := auth.NewGitHubAppAuth(config)
+	githubAuth, err := auth.NewGitHubAppAuth(appConfig)
 	if err != nil {
 		log.Fatalf("Failed to create GitHub App auth: %v", err)
 	}
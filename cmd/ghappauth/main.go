@@ -0,0 +1,584 @@
+// Command ghappauth mints GitHub App tokens for shell scripts and CI jobs.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"ghappauth/internal/audit"
+	"ghappauth/internal/auth"
+	"ghappauth/internal/config"
+	"ghappauth/internal/metrics"
+	"ghappauth/internal/policy"
+	"ghappauth/internal/server"
+	"ghappauth/internal/types"
+)
+
+const usage = `Usage: ghappauth <command> [flags]
+
+Commands:
+  token               Print an installation access token
+  jwt                 Print a JWT for the App
+  app                 Print information about the App as JSON
+  installations list  List the App's installations as JSON
+  revoke              Revoke an installation access token
+  serve               Serve installation tokens to local processes over a Unix socket
+
+Configuration is read from --config (or $GITHUB_APP_CONFIG), then
+GITHUB_APP_* environment variables, then command-line flags.
+
+Run 'ghappauth <command> -h' for the flags of a command.
+`
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// errUsage marks errors caused by invalid command-line usage
+var errUsage = errors.New("usage error")
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line and returns the process exit code
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(stderr, usage)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	var err error
+	switch args[0] {
+	case "token":
+		err = runToken(ctx, args[1:], stdout, stderr)
+	case "jwt":
+		err = runJWT(args[1:], stdout, stderr)
+	case "app":
+		err = runApp(ctx, args[1:], stdout, stderr)
+	case "installations":
+		err = runInstallations(ctx, args[1:], stdout, stderr)
+	case "revoke":
+		err = runRevoke(ctx, args[1:], stdin, stdout, stderr)
+	case "serve":
+		err = runServe(ctx, args[1:], stderr)
+	default:
+		err = fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "ghappauth: %v\n\n%s", strings.TrimPrefix(err.Error(), errUsage.Error()+": "), usage)
+		return exitUsage
+	default:
+		fmt.Fprintf(stderr, "ghappauth: %v\n", err)
+		return exitError
+	}
+}
+
+// configFlags holds the flags shared by all commands for loading App configuration
+type configFlags struct {
+	configFile string
+	app        string
+	overrides  types.GitHubAppConfig
+
+	// Only registered by commands that create tokens
+	policyFile string
+	auditLog   string
+}
+
+// register adds the configuration flags to fs
+func (c *configFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.configFile, "config", "", "config file (default $GITHUB_APP_CONFIG)")
+	fs.StringVar(&c.app, "app", "", "named App to use from the config file (default $GITHUB_APP_NAME)")
+	fs.StringVar(&c.overrides.AppID, "app-id", "", "GitHub App ID")
+	fs.StringVar(&c.overrides.PrivateKey, "private-key", "", "private key PEM or reference such as file:///path/key.pem")
+	fs.StringVar(&c.overrides.PrivateKeyPassphrase, "private-key-passphrase", "", "passphrase of an encrypted private key (default $GITHUB_APP_PRIVATE_KEY_PASSPHRASE)")
+	fs.StringVar(&c.overrides.InstallationID, "installation-id", "", "installation ID")
+	fs.StringVar(&c.overrides.BaseURL, "base-url", "", "GitHub API base URL")
+	fs.StringVar(&c.overrides.Deployment, "deployment", "", `set to "ghes" when --base-url is a GitHub Enterprise Server web URL`)
+}
+
+// registerTokenFlags adds the token policy and audit flags to fs
+func (c *configFlags) registerTokenFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.policyFile, "policy", "", "YAML token policy checked before tokens are created")
+	fs.StringVar(&c.auditLog, "audit-log", "", "file to append token events and policy decisions to as JSON lines")
+}
+
+// newAuth loads the configuration and creates the App authentication
+func (c *configFlags) newAuth(requireInstallation bool) (*auth.GitHubAppAuth, error) {
+	loader := &config.Loader{
+		Path:                 c.configFile,
+		App:                  c.app,
+		Overrides:            &c.overrides,
+		InstallationOptional: !requireInstallation,
+	}
+
+	appConfig, err := loader.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var githubAuth *auth.GitHubAppAuth
+	if requireInstallation {
+		githubAuth, err = auth.NewGitHubAppAuth(appConfig)
+	} else {
+		githubAuth, err = auth.NewAppAuth(appConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.auditLog != "" {
+		// Left open for the life of the process; every event is written unbuffered
+		sink, err := audit.NewFileSink(c.auditLog)
+		if err != nil {
+			return nil, err
+		}
+		// Policy decisions reach the sink through GitHubAppAuth as allow and deny events
+		githubAuth.SetAuditSink(sink)
+	}
+
+	if c.policyFile != "" {
+		tokenPolicy, err := policy.Load(c.policyFile)
+		if err != nil {
+			return nil, err
+		}
+		githubAuth.SetTokenPolicy(tokenPolicy)
+	}
+
+	return githubAuth, nil
+}
+
+// newFlagSet creates a flag set that reports errors instead of exiting
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("ghappauth "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+// parseFlags parses args and rejects positional arguments
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%w: unexpected argument %q", errUsage, fs.Arg(0))
+	}
+	return nil
+}
+
+// stringList is a repeatable flag collecting values, also split on commas
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+func runToken(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var (
+		flags       configFlags
+		repos       stringList
+		repoIDs     stringList
+		permissions stringList
+		format      string
+		envName     string
+		socket      string
+		caller      string
+	)
+
+	fs := newFlagSet("token", stderr)
+	flags.register(fs)
+	flags.registerTokenFlags(fs)
+	fs.StringVar(&caller, "caller", "", "caller or purpose the token is for, matched against --policy rules")
+	fs.Var(&repos, "repo", "limit the token to a repository name (repeatable)")
+	fs.Var(&repoIDs, "repo-id", "limit the token to a repository ID (repeatable)")
+	fs.Var(&permissions, "permission", "limit the token to a permission as name=level, e.g. contents=read (repeatable)")
+	fs.StringVar(&format, "format", "raw", "output format: raw, json or env")
+	fs.StringVar(&envName, "env-name", "GITHUB_TOKEN", "variable name used by --format env")
+	fs.StringVar(&socket, "socket", "", "request the token from a 'ghappauth serve' socket instead of using the private key")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if format != "raw" && format != "json" && format != "env" {
+		return fmt.Errorf("%w: invalid --format %q", errUsage, format)
+	}
+
+	request, err := tokenRequest(repos, repoIDs, permissions)
+	if err != nil {
+		return err
+	}
+
+	var token *types.GitHubAppToken
+	if socket != "" {
+		token, err = serverToken(ctx, socket, flags.overrides.InstallationID, repos, repoIDs, request)
+	} else {
+		token, err = localToken(policy.WithCaller(ctx, caller), &flags, request)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		return writeJSON(stdout, token)
+	case "env":
+		fmt.Fprintf(stdout, "export %s=%s\n", envName, shellQuote(token.Token))
+		fmt.Fprintf(stdout, "export %s_EXPIRES_AT=%s\n", envName, shellQuote(token.ExpiresAt.Format(time.RFC3339)))
+		return nil
+	default:
+		fmt.Fprintln(stdout, token.Token)
+		return nil
+	}
+}
+
+// localToken mints a token with the configured private key
+func localToken(ctx context.Context, flags *configFlags, request *types.InstallationTokenRequest) (*types.GitHubAppToken, error) {
+	githubAuth, err := flags.newAuth(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return githubAuth.CreateInstallationToken(ctx, githubAuth.InstallationID(), request)
+}
+
+// serverToken requests a token from a token server, which expects
+// repositories as owner/name
+func serverToken(ctx context.Context, socket, installationID string, repos, repoIDs []string, request *types.InstallationTokenRequest) (*types.GitHubAppToken, error) {
+	if len(repoIDs) > 0 {
+		return nil, fmt.Errorf("%w: --repo-id is not supported with --socket", errUsage)
+	}
+	for _, repo := range repos {
+		if !strings.Contains(repo, "/") {
+			return nil, fmt.Errorf("%w: --repo %q must be owner/name with --socket", errUsage, repo)
+		}
+	}
+
+	serverRequest := &server.TokenRequest{InstallationID: installationID, Repositories: repos}
+	if request != nil {
+		serverRequest.Permissions = request.Permissions
+	}
+
+	return server.NewClient(socket).Token(ctx, serverRequest)
+}
+
+// tokenRequest builds the scoping request from the token command flags, or nil when unscoped
+func tokenRequest(repos, repoIDs, permissions []string) (*types.InstallationTokenRequest, error) {
+	if len(repos) == 0 && len(repoIDs) == 0 && len(permissions) == 0 {
+		return nil, nil
+	}
+
+	request := &types.InstallationTokenRequest{}
+
+	for _, repo := range repos {
+		// Accept owner/name for convenience; GitHub expects the bare name
+		if _, name, ok := strings.Cut(repo, "/"); ok {
+			repo = name
+		}
+		request.Repositories = append(request.Repositories, repo)
+	}
+
+	for _, id := range repoIDs {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid --repo-id %q", errUsage, id)
+		}
+		request.RepositoryIDs = append(request.RepositoryIDs, n)
+	}
+
+	if len(permissions) > 0 {
+		request.Permissions = make(map[string]string, len(permissions))
+		for _, permission := range permissions {
+			name, level, ok := strings.Cut(permission, "=")
+			if !ok || name == "" || level == "" {
+				return nil, fmt.Errorf("%w: invalid --permission %q, expected name=level", errUsage, permission)
+			}
+			request.Permissions[name] = level
+		}
+	}
+
+	return request, nil
+}
+
+func runJWT(args []string, stdout, stderr io.Writer) error {
+	var flags configFlags
+
+	fs := newFlagSet("jwt", stderr)
+	flags.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	githubAuth, err := flags.newAuth(false)
+	if err != nil {
+		return err
+	}
+
+	jwt, err := githubAuth.GenerateJWT()
+	if err != nil {
+		return fmt.Errorf("failed to generate JWT: %w", err)
+	}
+
+	fmt.Fprintln(stdout, jwt)
+	return nil
+}
+
+func runApp(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var flags configFlags
+
+	fs := newFlagSet("app", stderr)
+	flags.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	githubAuth, err := flags.newAuth(false)
+	if err != nil {
+		return err
+	}
+
+	app, err := githubAuth.AppInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(stdout, app)
+}
+
+func runInstallations(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || args[0] != "list" {
+		return fmt.Errorf("%w: expected 'installations list'", errUsage)
+	}
+
+	var flags configFlags
+
+	fs := newFlagSet("installations list", stderr)
+	flags.register(fs)
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	githubAuth, err := flags.newAuth(false)
+	if err != nil {
+		return err
+	}
+
+	installations, err := githubAuth.ListInstallations(ctx)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(installations, func(i, j int) bool { return installations[i].ID < installations[j].ID })
+	if installations == nil {
+		installations = []types.GitHubAppInstallation{}
+	}
+
+	return writeJSON(stdout, installations)
+}
+
+// runRevoke needs no App credentials: GitHub authenticates the request with the token being revoked
+func runRevoke(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var (
+		token      string
+		baseURL    string
+		deployment string
+	)
+
+	fs := newFlagSet("revoke", stderr)
+	fs.StringVar(&token, "token", "", "token to revoke, '-' to read it from stdin (default $GITHUB_TOKEN)")
+	fs.StringVar(&baseURL, "base-url", "", "GitHub API base URL (default $GITHUB_APP_BASE_URL)")
+	fs.StringVar(&deployment, "deployment", "", `set to "ghes" when --base-url is a GitHub Enterprise Server web URL (default $GITHUB_APP_DEPLOYMENT)`)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if token == "-" {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read token from stdin: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+	if token == "" {
+		return fmt.Errorf("%w: no token given, use --token or set GITHUB_TOKEN", errUsage)
+	}
+
+	if baseURL == "" {
+		baseURL = os.Getenv(config.EnvBaseURL)
+	}
+	if deployment == "" {
+		deployment = os.Getenv(config.EnvDeployment)
+	}
+
+	if err := auth.RevokeToken(ctx, baseURL, auth.Deployment(deployment), token); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stderr, "token revoked")
+	return nil
+}
+
+func runServe(ctx context.Context, args []string, stderr io.Writer) error {
+	var (
+		flags       configFlags
+		socket      string
+		socketMode  string
+		clientsFile string
+		renewBuffer time.Duration
+		metricsAddr string
+		watchKey    time.Duration
+	)
+
+	fs := newFlagSet("serve", stderr)
+	flags.register(fs)
+	flags.registerTokenFlags(fs)
+	fs.StringVar(&socket, "socket", server.DefaultSocketPath(), "Unix socket to listen on")
+	fs.StringVar(&socketMode, "socket-mode", "0600", "permissions of the socket file")
+	fs.StringVar(&clientsFile, "clients", "", "YAML file with client policies (default only the current user may request tokens)")
+	fs.DurationVar(&renewBuffer, "renew-buffer", 0, "renew cached tokens this long before they expire (default 5m)")
+	fs.StringVar(&metricsAddr, "metrics-addr", "", "TCP address to serve Prometheus metrics on at /metrics, such as localhost:9090")
+	fs.DurationVar(&watchKey, "watch-key", 30*time.Second, "check a file:// private key for changes this often, 0 to disable")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if watchKey < 0 {
+		return fmt.Errorf("%w: --watch-key must not be negative", errUsage)
+	}
+
+	mode, err := strconv.ParseUint(socketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("%w: invalid --socket-mode %q", errUsage, socketMode)
+	}
+
+	clients := server.OwnerPolicy()
+	if clientsFile != "" {
+		if clients, err = server.LoadClientPolicies(clientsFile); err != nil {
+			return err
+		}
+	}
+
+	githubAuth, err := flags.newAuth(false)
+	if err != nil {
+		return err
+	}
+
+	listener, err := server.Listen(socket, os.FileMode(mode))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if metricsAddr != "" {
+		registry := metrics.NewRegistry()
+		githubAuth.SetMetrics(registry)
+		if err := serveMetrics(ctx, metricsAddr, registry, stderr); err != nil {
+			return err
+		}
+	}
+
+	// Warnings, such as a rotated key that fails to load, go to stderr
+	githubAuth.SetLogger(slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	if err := watchPrivateKey(ctx, githubAuth, watchKey, stderr); err != nil {
+		return err
+	}
+
+	// Drop the tokens of installations that are no longer requested once they expire
+	tokenManager := auth.NewTokenManager(githubAuth, renewBuffer)
+	if err := tokenManager.SweepCache(ctx, time.Minute); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stderr, "serving tokens on %s\n", socket)
+	return server.New(githubAuth, tokenManager, clients).Serve(ctx, listener)
+}
+
+// watchPrivateKey picks up a file:// private key rotated on disk every
+// interval until ctx is done. Other keys, or an interval of 0, are not watched.
+func watchPrivateKey(ctx context.Context, githubAuth *auth.GitHubAppAuth, interval time.Duration, stderr io.Writer) error {
+	path, ok := githubAuth.PrivateKeyFile()
+	if !ok || interval == 0 {
+		return nil
+	}
+
+	if err := githubAuth.WatchPrivateKey(ctx, interval); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "watching private key file %s\n", path)
+	return nil
+}
+
+// serveMetrics serves the registry at /metrics on addr until ctx is done
+func serveMetrics(ctx context.Context, addr string, registry *metrics.Registry, stderr io.Writer) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go httpServer.Serve(listener)
+	go func() {
+		<-ctx.Done()
+		httpServer.Close()
+	}()
+
+	fmt.Fprintf(stderr, "serving metrics on http://%s/metrics\n", listener.Addr())
+	return nil
+}
+
+// writeJSON writes v to w as indented JSON
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// shellQuote quotes s for safe use in a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
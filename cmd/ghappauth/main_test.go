@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ghappauth/internal/auth"
+	"ghappauth/internal/types"
+
+	"github.com/youmark/pkcs8"
+)
+
+// newTestServer fakes the GitHub API endpoints used by the CLI
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/app/installations/67890/access_tokens":
+			var request types.InstallationTokenRequest
+			if r.ContentLength > 0 {
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+					t.Errorf("Failed to decode token request: %v", err)
+				}
+			}
+			permissions, _ := json.Marshal(request.Permissions)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"token": "ghs_test", "expires_at": "2030-01-01T00:00:00Z", "repositories": [` +
+				repositoriesJSON(request.Repositories) + `], "permissions": ` + string(permissions) + `}`))
+		case r.Method == "POST":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not Found"}`))
+		case r.Method == "GET" && r.URL.Path == "/app":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"id": 12345, "slug": "test-app"}`))
+		case r.Method == "GET" && r.URL.Path == "/app/installations":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`[{"id": 2, "account": {"login": "b"}}, {"id": 1, "account": {"login": "a"}}]`))
+		case r.Method == "DELETE" && r.URL.Path == "/installation/token":
+			if r.Header.Get("Authorization") != "Bearer ghs_revoke" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"message": "Bad credentials"}`))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}))
+}
+
+func repositoriesJSON(names []string) string {
+	repos := make([]string, len(names))
+	for i, name := range names {
+		repos[i] = `{"name": "` + name + `"}`
+	}
+	return strings.Join(repos, ",")
+}
+
+func testKeyPEM(t *testing.T) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+func encryptedTestKeyPEM(t *testing.T, passphrase string) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := pkcs8.ConvertPrivateKeyToPKCS8(key, []byte(passphrase))
+	if err != nil {
+		t.Fatalf("Failed to encrypt key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}))
+}
+
+func TestRun(t *testing.T) {
+	for _, env := range []string{"GITHUB_APP_CONFIG", "GITHUB_APP_NAME", "GITHUB_APP_ID", "GITHUB_APP_PRIVATE_KEY", "GITHUB_APP_INSTALLATION_ID", "GITHUB_APP_BASE_URL", "GITHUB_PRIVATE_KEY", "GITHUB_INSTALLATION_ID", "GITHUB_TOKEN"} {
+		t.Setenv(env, "")
+	}
+
+	server := newTestServer(t)
+	defer server.Close()
+
+	key := testKeyPEM(t)
+	common := []string{"--app-id", "12345", "--private-key", key, "--base-url", server.URL}
+
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, "policy.yaml")
+	auditLog := filepath.Join(dir, "audit.jsonl")
+	os.WriteFile(policyFile, []byte("rules:\n  - name: ci\n    callers: [ci]\n    permissions: {contents: read}\n"), 0600)
+	withPolicy := append([]string{"--installation-id", "67890", "--policy", policyFile, "--audit-log", auditLog}, common...)
+
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "raw token",
+			args:       append([]string{"token", "--installation-id", "67890"}, common...),
+			wantCode:   exitOK,
+			wantStdout: "ghs_test\n",
+		},
+		{
+			name:       "scoped token as JSON",
+			args:       append([]string{"token", "--installation-id", "67890", "--repo", "octo/hello", "--permission", "contents=read", "--format", "json"}, common...),
+			wantCode:   exitOK,
+			wantStdout: `"name": "hello"`,
+		},
+		{
+			name:       "token as env exports",
+			args:       append([]string{"token", "--installation-id", "67890", "--format", "env", "--env-name", "GH_TOKEN"}, common...),
+			wantCode:   exitOK,
+			wantStdout: "export GH_TOKEN='ghs_test'\nexport GH_TOKEN_EXPIRES_AT='2030-01-01T00:00:00Z'\n",
+		},
+		{
+			name:       "API error",
+			args:       append([]string{"token", "--installation-id", "1"}, common...),
+			wantCode:   exitError,
+			wantStderr: "GitHub API error: Not Found (status: 404)",
+		},
+		{
+			name:       "missing installation",
+			args:       append([]string{"token"}, common...),
+			wantCode:   exitError,
+			wantStderr: "installation_id is required",
+		},
+		{
+			name:       "invalid permission",
+			args:       append([]string{"token", "--installation-id", "67890", "--permission", "contents"}, common...),
+			wantCode:   exitUsage,
+			wantStderr: "expected name=level",
+		},
+		{
+			name:       "allowed by policy",
+			args:       append([]string{"token", "--caller", "ci", "--permission", "contents=read"}, withPolicy...),
+			wantCode:   exitOK,
+			wantStdout: "ghs_test\n",
+		},
+		{
+			name:       "denied by policy",
+			args:       append([]string{"token", "--caller", "ci", "--permission", "administration=write"}, withPolicy...),
+			wantCode:   exitError,
+			wantStderr: "token request denied: permission administration is not allowed",
+		},
+		{
+			name:       "jwt",
+			args:       append([]string{"jwt"}, common...),
+			wantCode:   exitOK,
+			wantStdout: "eyJ",
+		},
+		{
+			name:       "app info",
+			args:       append([]string{"app"}, common...),
+			wantCode:   exitOK,
+			wantStdout: `"slug": "test-app"`,
+		},
+		{
+			name:       "installations list",
+			args:       append([]string{"installations", "list"}, common...),
+			wantCode:   exitOK,
+			wantStdout: `"login": "a"`,
+		},
+		{
+			name:       "revoke from stdin",
+			args:       []string{"revoke", "--token", "-", "--base-url", server.URL}, // No App credentials needed
+			stdin:      "ghs_revoke\n",
+			wantCode:   exitOK,
+			wantStderr: "token revoked",
+		},
+		{
+			name:       "revoke rejected",
+			args:       []string{"revoke", "--token", "ghs_other", "--base-url", server.URL},
+			wantCode:   exitError,
+			wantStderr: "Bad credentials",
+		},
+		{
+			name:       "unknown command",
+			args:       []string{"frobnicate"},
+			wantCode:   exitUsage,
+			wantStderr: `unknown command "frobnicate"`,
+		},
+		{
+			name:     "no command",
+			args:     nil,
+			wantCode: exitUsage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(context.Background(), tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+			if code != tt.wantCode {
+				t.Errorf("run() = %d, want %d (stderr: %s)", code, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("Expected stdout to contain %q, got %q", tt.wantStdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("Expected stderr to contain %q, got %q", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+
+	// allow, mint, deny
+	events, err := os.ReadFile(auditLog)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if lines := strings.Count(string(events), "\n"); lines != 3 {
+		t.Errorf("Expected 3 audit events, got %d: %s", lines, events)
+	}
+	if strings.Contains(string(events), "ghs_test") {
+		t.Error("The audit log must not contain tokens")
+	}
+}
+
+func TestRun_EncryptedPrivateKey(t *testing.T) {
+	for _, env := range []string{"GITHUB_APP_CONFIG", "GITHUB_APP_NAME", "GITHUB_APP_ID", "GITHUB_APP_PRIVATE_KEY", "GITHUB_APP_PRIVATE_KEY_PASSPHRASE", "GITHUB_APP_INSTALLATION_ID", "GITHUB_APP_BASE_URL"} {
+		t.Setenv(env, "")
+	}
+
+	server := newTestServer(t)
+	defer server.Close()
+
+	common := []string{"app", "--app-id", "12345", "--private-key", encryptedTestKeyPEM(t, "s3cret"), "--base-url", server.URL}
+
+	var stdout, stderr bytes.Buffer
+	if code := run(context.Background(), common, strings.NewReader(""), &stdout, &stderr); code != exitError {
+		t.Errorf("run() without a passphrase = %d, want %d", code, exitError)
+	}
+
+	stderr.Reset()
+	args := append(append([]string{}, common...), "--private-key-passphrase", "s3cret")
+	if code := run(context.Background(), args, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+		t.Errorf("run() with --private-key-passphrase = %d, want %d (stderr: %s)", code, exitOK, stderr.String())
+	}
+
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_PASSPHRASE", "s3cret")
+	stderr.Reset()
+	if code := run(context.Background(), common, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+		t.Errorf("run() with GITHUB_APP_PRIVATE_KEY_PASSPHRASE = %d, want %d (stderr: %s)", code, exitOK, stderr.String())
+	}
+}
+
+func TestRun_CanceledContext(t *testing.T) {
+	for _, env := range []string{"GITHUB_APP_CONFIG", "GITHUB_APP_NAME", "GITHUB_APP_ID", "GITHUB_APP_PRIVATE_KEY", "GITHUB_APP_INSTALLATION_ID", "GITHUB_APP_BASE_URL"} {
+		t.Setenv(env, "")
+	}
+
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var stdout, stderr bytes.Buffer
+	code := run(ctx, []string{"app", "--app-id", "12345", "--private-key", testKeyPEM(t), "--base-url", server.URL}, strings.NewReader(""), &stdout, &stderr)
+	if code != exitError {
+		t.Errorf("run() with a canceled context = %d, want %d", code, exitError)
+	}
+	if !strings.Contains(stderr.String(), "context canceled") {
+		t.Errorf("Expected stderr to mention the canceled context, got %q", stderr.String())
+	}
+}
+
+func TestWatchPrivateKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyFile, []byte(testKeyPEM(t)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		privateKey  string
+		interval    time.Duration
+		wantWatched bool
+	}{
+		{name: "file key", privateKey: "file://" + keyFile, interval: time.Hour, wantWatched: true},
+		{name: "disabled", privateKey: "file://" + keyFile, interval: 0},
+		{name: "inline key", privateKey: testKeyPEM(t), interval: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			githubAuth, err := auth.NewAppAuth(&types.GitHubAppConfig{AppID: "12345", PrivateKey: tt.privateKey})
+			if err != nil {
+				t.Fatalf("NewAppAuth() error = %v", err)
+			}
+
+			var stderr bytes.Buffer
+			if err := watchPrivateKey(t.Context(), githubAuth, tt.interval, &stderr); err != nil {
+				t.Fatalf("watchPrivateKey() error = %v", err)
+			}
+
+			// SetPrivateKeys is rejected while the key file is watched
+			watched := githubAuth.SetPrivateKeys(testKeyPEM(t)) != nil
+			if watched != tt.wantWatched {
+				t.Errorf("Expected watched = %v, got %v", tt.wantWatched, watched)
+			}
+			if got := strings.Contains(stderr.String(), "watching private key file"); got != tt.wantWatched {
+				t.Errorf("Unexpected stderr %q", stderr.String())
+			}
+		})
+	}
+}
+
+func TestTokenRequest(t *testing.T) {
+	request, err := tokenRequest(nil, nil, nil)
+	if err != nil || request != nil {
+		t.Errorf("tokenRequest() with no scopes = %v, %v; want nil, nil", request, err)
+	}
+
+	request, err = tokenRequest([]string{"octo/hello", "world"}, []string{"42"}, []string{"contents=read", "issues=write"})
+	if err != nil {
+		t.Fatalf("tokenRequest() error = %v", err)
+	}
+	if strings.Join(request.Repositories, ",") != "hello,world" {
+		t.Errorf("Expected repositories hello,world, got %v", request.Repositories)
+	}
+	if len(request.RepositoryIDs) != 1 || request.RepositoryIDs[0] != 42 {
+		t.Errorf("Expected repository ID 42, got %v", request.RepositoryIDs)
+	}
+	if request.Permissions["contents"] != "read" || request.Permissions["issues"] != "write" {
+		t.Errorf("Unexpected permissions %v", request.Permissions)
+	}
+
+	if _, err := tokenRequest(nil, []string{"abc"}, nil); err == nil {
+		t.Error("tokenRequest() should reject a non-numeric repository ID")
+	}
+}
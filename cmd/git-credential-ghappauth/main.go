@@ -0,0 +1,79 @@
+// Command git-credential-ghappauth is a git credential helper that answers
+// with GitHub App installation tokens.
+//
+// Configure it for a GitHub host with:
+//
+//	git config --global credential.https://github.com.helper ghappauth
+//	git config --global credential.https://github.com.useHttpPath true
+//
+// With useHttpPath enabled the installation is resolved from the repository
+// being accessed; otherwise the configured installation_id is used.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"ghappauth/internal/auth"
+	"ghappauth/internal/config"
+	"ghappauth/internal/gitcredential"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "git-credential-ghappauth: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var (
+		configFile string
+		app        string
+		cacheDir   string
+		noCache    bool
+	)
+
+	fs := flag.NewFlagSet("git-credential-ghappauth", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&configFile, "config", "", "config file (default $GITHUB_APP_CONFIG)")
+	fs.StringVar(&app, "app", "", "named App to use from the config file (default $GITHUB_APP_NAME)")
+	fs.StringVar(&cacheDir, "cache-dir", "", "directory for cached tokens (default user cache directory)")
+	fs.BoolVar(&noCache, "no-cache", false, "do not cache tokens between invocations")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: git-credential-ghappauth [flags] get|store|erase")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one operation")
+	}
+
+	appConfig, err := (&config.Loader{Path: configFile, App: app, InstallationOptional: true}).Load()
+	if err != nil {
+		return err
+	}
+
+	githubAuth, err := auth.NewAppAuth(appConfig)
+	if err != nil {
+		return err
+	}
+
+	var cache *gitcredential.FileCache
+	if !noCache {
+		cache, err = gitcredential.NewFileCache(cacheDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	helper := gitcredential.NewHelper(githubAuth, auth.NewTokenManager(githubAuth, 0), cache)
+	return helper.Run(ctx, fs.Arg(0), stdin, stdout)
+}
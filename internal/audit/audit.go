@@ -0,0 +1,141 @@
+// Package audit records what happens to installation tokens: when they are
+// minted, served from cache, renewed, invalidated or revoked, and for whom.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"ghappauth/internal/policy"
+)
+
+// EventType identifies what happened to a token
+type EventType string
+
+const (
+	// EventMint is a new token created by GitHub
+	EventMint EventType = "mint"
+	// EventCacheHit is a cached token handed out again
+	EventCacheHit EventType = "cache_hit"
+	// EventRenew is a cached token replaced because it was about to expire
+	EventRenew EventType = "renew"
+	// EventInvalidate is a token dropped from the cache
+	EventInvalidate EventType = "invalidate"
+	// EventRevoke is a token revoked at GitHub
+	EventRevoke EventType = "revoke"
+	// EventAllow and EventDeny are token policy decisions
+	EventAllow EventType = "allow"
+	EventDeny  EventType = "deny"
+)
+
+// Event describes one thing that happened to a token. Tokens themselves are
+// never recorded, only their fingerprint.
+type Event struct {
+	Time           time.Time `json:"time"`
+	Type           EventType `json:"type"`
+	AppID          string    `json:"app_id,omitempty"`
+	InstallationID string    `json:"installation_id,omitempty"`
+	// Repositories and RepositoryIDs are the requested repository scope
+	Repositories  []string `json:"repositories,omitempty"`
+	RepositoryIDs []int    `json:"repository_ids,omitempty"`
+	// Permissions are the permissions granted to the token, or requested
+	// when no token was issued
+	Permissions map[string]string `json:"permissions,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"`
+	// Caller is the caller set with policy.WithCaller
+	Caller string            `json:"caller,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Rule and Reason explain policy decisions
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Sink receives audit events. Emit is called synchronously on the path that
+// issues tokens, so it should not block for long.
+type Sink interface {
+	Emit(event Event)
+}
+
+// SinkFunc adapts a function to Sink
+type SinkFunc func(event Event)
+
+// Emit calls f(event)
+func (f SinkFunc) Emit(event Event) {
+	f(event)
+}
+
+// MultiSink sends every event to each of its sinks
+type MultiSink []Sink
+
+// Emit sends event to each sink in order
+func (m MultiSink) Emit(event Event) {
+	for _, sink := range m {
+		sink.Emit(event)
+	}
+}
+
+// Fingerprint identifies a token in logs without revealing it
+func Fingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+type labelsKey struct{}
+
+// WithLabels returns a context whose audit events carry labels, in addition
+// to any labels already set on ctx
+func WithLabels(ctx context.Context, labels map[string]string) context.Context {
+	merged := make(map[string]string)
+	for key, value := range LabelsFromContext(ctx) {
+		merged[key] = value
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, labelsKey{}, merged)
+}
+
+// LabelsFromContext returns the labels set with WithLabels
+func LabelsFromContext(ctx context.Context) map[string]string {
+	labels, _ := ctx.Value(labelsKey{}).(map[string]string)
+	return labels
+}
+
+// FromContext fills in the time, caller and labels of an event
+func FromContext(ctx context.Context, event Event) Event {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Caller == "" {
+		event.Caller = policy.CallerFromContext(ctx)
+	}
+	if event.Labels == nil {
+		event.Labels = LabelsFromContext(ctx)
+	}
+	return event
+}
+
+// DecisionEvent describes a token policy decision as an allow or deny event
+func DecisionEvent(decision policy.Decision) Event {
+	event := Event{
+		Time:           decision.Time,
+		Type:           EventDeny,
+		InstallationID: decision.InstallationID,
+		Repositories:   decision.Repositories,
+		RepositoryIDs:  decision.RepositoryIDs,
+		Permissions:    decision.Permissions,
+		Caller:         decision.Caller,
+		Rule:           decision.Rule,
+		Reason:         decision.Reason,
+	}
+	if decision.Allowed {
+		event.Type = EventAllow
+	}
+	return event
+}
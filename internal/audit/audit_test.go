@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ghappauth/internal/policy"
+)
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := Fingerprint("ghs_secret")
+	if !strings.HasPrefix(fingerprint, "sha256:") || len(fingerprint) != len("sha256:")+16 {
+		t.Errorf("Unexpected fingerprint %q", fingerprint)
+	}
+	if strings.Contains(fingerprint, "secret") {
+		t.Error("Fingerprint must not contain the token")
+	}
+	if Fingerprint("ghs_secret") != fingerprint || Fingerprint("ghs_other") == fingerprint {
+		t.Error("Fingerprints should be stable and distinct per token")
+	}
+	if Fingerprint("") != "" {
+		t.Error("Empty tokens should have no fingerprint")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	ctx := WithLabels(context.Background(), map[string]string{"job": "build", "step": "1"})
+	ctx = WithLabels(ctx, map[string]string{"step": "2"})
+	ctx = policy.WithCaller(ctx, "ci")
+
+	event := FromContext(ctx, Event{Type: EventMint})
+	if event.Time.IsZero() || event.Caller != "ci" {
+		t.Errorf("Expected time and caller to be set, got %+v", event)
+	}
+	if event.Labels["job"] != "build" || event.Labels["step"] != "2" {
+		t.Errorf("Expected merged labels, got %v", event.Labels)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	sink.Emit(Event{Type: EventMint, InstallationID: "42", Fingerprint: Fingerprint("ghs_a"), ExpiresAt: &expiresAt})
+
+	sink.Emit(DecisionEvent(policy.Decision{InstallationID: "42", Caller: "ci", Rule: "ci", Reason: "no"}))
+
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected audit log mode 0600, got %v (%v)", info.Mode().Perm(), err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	if events[0].Type != EventMint || !events[0].ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Unexpected mint event %+v", events[0])
+	}
+	if events[1].Type != EventDeny || events[1].Caller != "ci" || events[1].Reason != "no" {
+		t.Errorf("Unexpected decision event %+v", events[1])
+	}
+}
+
+// failingWriter fails every write with err
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func TestJSONSink_WriteError(t *testing.T) {
+	errDiskFull := errors.New("no space left on device")
+	sink := NewJSONSink(failingWriter{err: errDiskFull})
+
+	if err := sink.Err(); err != nil {
+		t.Fatalf("Err() before any event = %v", err)
+	}
+
+	sink.Emit(Event{Type: EventMint, InstallationID: "42"})
+	sink.Emit(Event{Type: EventRevoke})
+
+	err := sink.Err()
+	if !errors.Is(err, errDiskFull) || !strings.Contains(err.Error(), "failed to write 2 audit events") {
+		t.Errorf("Err() = %v, want 2 lost events wrapping %v", err, errDiskFull)
+	}
+	if err := sink.Close(); !errors.Is(err, errDiskFull) {
+		t.Errorf("Close() = %v, want it to wrap %v", err, errDiskFull)
+	}
+}
@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// JSONSink writes each event to a writer as one line of JSON. Events that
+// cannot be written are counted, and Err and Close report them.
+type JSONSink struct {
+	mutex    sync.Mutex
+	encoder  *json.Encoder
+	closer   io.Closer
+	writeErr error // First error writing an event
+	lost     int   // Events that could not be written
+}
+
+// NewJSONSink creates a sink writing JSON lines to w
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{encoder: json.NewEncoder(w)}
+}
+
+// NewFileSink creates a sink appending JSON lines to the file at path,
+// creating it readable only by the current user
+func NewFileSink(path string) (*JSONSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	sink := NewJSONSink(file)
+	sink.closer = file
+	return sink, nil
+}
+
+// Emit writes the event as a JSON line
+func (s *JSONSink) Emit(event Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.encoder.Encode(event); err != nil {
+		s.lost++
+		if s.writeErr == nil {
+			s.writeErr = err
+		}
+	}
+}
+
+// Err reports the events that could not be written so far, wrapping the
+// first write error, or returns nil if every event was written
+func (s *JSONSink) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.err()
+}
+
+func (s *JSONSink) err() error {
+	if s.writeErr == nil {
+		return nil
+	}
+	return fmt.Errorf("failed to write %d audit events: %w", s.lost, s.writeErr)
+}
+
+// Close closes the file of a sink created with NewFileSink. It returns the
+// error Err would, so events lost to a full disk or closed file are not
+// dropped silently.
+func (s *JSONSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var closeErr error
+	if s.closer != nil {
+		closeErr = s.closer.Close()
+	}
+	return errors.Join(s.err(), closeErr)
+}
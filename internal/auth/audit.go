@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+
+	"ghappauth/internal/audit"
+	"ghappauth/internal/types"
+)
+
+// SetAuditSink sets where token events are recorded: tokens minted by
+// CreateInstallationToken, tokens minted, served, renewed and invalidated by
+// a TokenManager, and revocations. A nil sink disables auditing.
+func (g *GitHubAppAuth) SetAuditSink(sink audit.Sink) {
+	g.hooksMutex.Lock()
+	defer g.hooksMutex.Unlock()
+
+	g.auditSink = sink
+}
+
+// emit sends an event to the audit sink, if one is set
+func (g *GitHubAppAuth) emit(ctx context.Context, event audit.Event) {
+	g.hooksMutex.RLock()
+	sink := g.auditSink
+	g.hooksMutex.RUnlock()
+
+	if sink == nil {
+		return
+	}
+
+	event.AppID = g.config.AppID
+	sink.Emit(audit.FromContext(ctx, event))
+}
+
+// tokenEvent describes an installation token and the scope it was requested with
+func tokenEvent(eventType audit.EventType, installationID string, request *types.InstallationTokenRequest, token *types.GitHubAppToken) audit.Event {
+	event := audit.Event{
+		Type:           eventType,
+		InstallationID: installationID,
+	}
+
+	if request != nil {
+		event.Repositories = request.Repositories
+		event.RepositoryIDs = request.RepositoryIDs
+		event.Permissions = request.Permissions
+	}
+
+	if token != nil {
+		event.Fingerprint = audit.Fingerprint(token.Token)
+		expiresAt := token.ExpiresAt
+		event.ExpiresAt = &expiresAt
+		if len(token.Permissions) > 0 {
+			event.Permissions = token.Permissions
+		}
+	}
+
+	return event
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"ghappauth/internal/types"
+)
+
+// CacheStats is a snapshot of a TokenManager cache. Durations are encoded in
+// JSON as strings such as "5m0s".
+type CacheStats struct {
+	TotalCached int           `json:"total_cached"`
+	RenewBuffer time.Duration `json:"renew_buffer"`
+	// CacheDetails describes each cached token by cache key: the installation
+	// ID for unscoped tokens, followed by the scope for scoped ones
+	CacheDetails map[string]CacheEntryStats `json:"cache_details"`
+
+	// Counters since the TokenManager was created
+	Hits     uint64 `json:"hits"`     // Tokens served from the cache
+	Misses   uint64 `json:"misses"`   // Requests that found no cached token
+	Renewals uint64 `json:"renewals"` // Cached tokens renewed before expiry
+	Failures uint64 `json:"failures"` // Failed mints and renewals
+	Stale    uint64 `json:"stale"`    // Tokens served after their renewal failed or while it was backed off
+	// Evictions counts the least recently used tokens evicted to stay within MaxEntries
+	Evictions uint64 `json:"evictions"`
+	// Expired counts the expired tokens removed by PruneExpired and SweepCache
+	Expired uint64 `json:"expired"`
+	// DroppedEvents counts token events dropped because subscribers fell behind
+	DroppedEvents uint64 `json:"dropped_events"`
+	// MaxEntries is the bound on the number of cached tokens; 0 means unbounded
+	MaxEntries int `json:"max_entries"`
+}
+
+// CacheEntryStats describes one cached token
+type CacheEntryStats struct {
+	InstallationID string                          `json:"installation_id"`
+	Scope          *types.InstallationTokenRequest `json:"scope,omitempty"`
+	CreatedAt      time.Time                       `json:"created_at"`
+	LastUsed       time.Time                       `json:"last_used"`
+	ExpiresAt      time.Time                       `json:"expires_at"`
+	TimeRemaining  time.Duration                   `json:"time_remaining"`
+	IsExpired      bool                            `json:"is_expired"`
+	Renewing       bool                            `json:"renewing"`
+	RenewCount     int                             `json:"renew_count"`
+	// LastError is the error of the last failed renewal, cleared when a renewal succeeds
+	LastError string `json:"last_error,omitempty"`
+	// NextRenewal is when renewal is attempted again after a failure, if the token is served stale
+	NextRenewal time.Time `json:"next_renewal,omitzero"`
+}
+
+// cacheCounters counts cache activity since a TokenManager was created
+type cacheCounters struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	renewals  atomic.Uint64
+	failures  atomic.Uint64
+	stale     atomic.Uint64
+	evictions atomic.Uint64
+	expired   atomic.Uint64
+
+	droppedEvents atomic.Uint64
+}
+
+// GetCacheStats returns statistics about the token cache
+func (tm *TokenManager) GetCacheStats() CacheStats {
+	tm.cache.mutex.RLock()
+	defer tm.cache.mutex.RUnlock()
+
+	stats := CacheStats{
+		TotalCached:   len(tm.cache.entries),
+		RenewBuffer:   tm.renewBuffer,
+		CacheDetails:  make(map[string]CacheEntryStats, len(tm.cache.entries)),
+		Hits:          tm.counters.hits.Load(),
+		Misses:        tm.counters.misses.Load(),
+		Renewals:      tm.counters.renewals.Load(),
+		Failures:      tm.counters.failures.Load(),
+		Stale:         tm.counters.stale.Load(),
+		Evictions:     tm.counters.evictions.Load(),
+		Expired:       tm.counters.expired.Load(),
+		DroppedEvents: tm.counters.droppedEvents.Load(),
+		MaxEntries:    tm.cache.maxEntries,
+	}
+
+	now := tm.now()
+	for key, element := range tm.cache.entries {
+		cached := element.Value.(*cachedToken)
+		state := cached.load()
+
+		entry := CacheEntryStats{
+			InstallationID: cached.installationID,
+			Scope:          cached.request,
+			CreatedAt:      state.createdAt,
+			LastUsed:       cached.lastUsed,
+			ExpiresAt:      state.token.ExpiresAt,
+			TimeRemaining:  max(state.token.ExpiresAt.Sub(now), 0),
+			IsExpired:      tm.IsTokenExpired(state.token, 0),
+			Renewing:       state.renewing,
+			RenewCount:     state.renewCount,
+			NextRenewal:    state.nextRenewal,
+		}
+		if state.lastError != nil {
+			entry.LastError = state.lastError.Error()
+		}
+		stats.CacheDetails[key] = entry
+	}
+
+	return stats
+}
+
+// cacheStatsJSON is the JSON form of CacheStats
+type cacheStatsJSON struct {
+	*cacheStatsAlias
+	RenewBuffer string `json:"renew_buffer"`
+}
+
+type cacheStatsAlias CacheStats
+
+// MarshalJSON encodes the renew buffer as a duration string
+func (s CacheStats) MarshalJSON() ([]byte, error) {
+	alias := cacheStatsAlias(s)
+	return json.Marshal(cacheStatsJSON{cacheStatsAlias: &alias, RenewBuffer: s.RenewBuffer.String()})
+}
+
+// UnmarshalJSON decodes the JSON produced by MarshalJSON
+func (s *CacheStats) UnmarshalJSON(data []byte) error {
+	decoded := cacheStatsJSON{cacheStatsAlias: (*cacheStatsAlias)(s)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	buffer, err := parseJSONDuration("renew_buffer", decoded.RenewBuffer)
+	if err != nil {
+		return err
+	}
+	s.RenewBuffer = buffer
+	return nil
+}
+
+// cacheEntryStatsJSON is the JSON form of CacheEntryStats
+type cacheEntryStatsJSON struct {
+	*cacheEntryStatsAlias
+	TimeRemaining string `json:"time_remaining"`
+}
+
+type cacheEntryStatsAlias CacheEntryStats
+
+// MarshalJSON encodes the time remaining as a duration string
+func (e CacheEntryStats) MarshalJSON() ([]byte, error) {
+	alias := cacheEntryStatsAlias(e)
+	return json.Marshal(cacheEntryStatsJSON{cacheEntryStatsAlias: &alias, TimeRemaining: e.TimeRemaining.String()})
+}
+
+// UnmarshalJSON decodes the JSON produced by MarshalJSON
+func (e *CacheEntryStats) UnmarshalJSON(data []byte) error {
+	decoded := cacheEntryStatsJSON{cacheEntryStatsAlias: (*cacheEntryStatsAlias)(e)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	remaining, err := parseJSONDuration("time_remaining", decoded.TimeRemaining)
+	if err != nil {
+		return err
+	}
+	e.TimeRemaining = remaining
+	return nil
+}
+
+// parseJSONDuration parses a duration string, treating "" as zero
+func parseJSONDuration(field, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", field, err)
+	}
+	return duration, nil
+}
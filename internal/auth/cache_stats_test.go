@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ghappauth/internal/types"
+)
+
+func TestTokenManager_CacheStatsCounters(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"suspended"}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{
+			Token:     "ghs_secret",
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	tm := NewTokenManager(auth, 5*time.Minute)
+	request := &types.InstallationTokenRequest{Repositories: []string{"app"}}
+	if _, err := tm.GetScopedToken(t.Context(), "67890", request); err != nil {
+		t.Fatalf("GetScopedToken() error = %v", err)
+	}
+	if _, err := tm.GetScopedToken(t.Context(), "67890", request); err != nil {
+		t.Fatalf("GetScopedToken() error = %v", err)
+	}
+
+	// A renew buffer longer than the token lifetime forces a renewal
+	tm.SetRenewBuffer(2 * time.Hour)
+	if _, err := tm.GetScopedToken(t.Context(), "67890", request); err != nil {
+		t.Fatalf("GetScopedToken() error = %v", err)
+	}
+	fail = true
+	if _, err := tm.GetScopedToken(t.Context(), "67890", request); err == nil {
+		t.Fatal("Expected the renewal to fail")
+	}
+
+	stats := tm.GetCacheStats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Renewals != 1 || stats.Failures != 1 {
+		t.Errorf("Unexpected counters %+v", stats)
+	}
+
+	key, _ := cacheKey("67890", request)
+	entry, ok := stats.CacheDetails[key]
+	if !ok {
+		t.Fatalf("Expected an entry for %q, got %+v", key, stats.CacheDetails)
+	}
+	if entry.InstallationID != "67890" || entry.Scope == nil || entry.Scope.Repositories[0] != "app" {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	if entry.RenewCount != 1 || entry.LastError == "" {
+		t.Errorf("Expected 1 renewal and the last error, got %d and %q", entry.RenewCount, entry.LastError)
+	}
+	if entry.TimeRemaining <= 55*time.Minute || entry.TimeRemaining > time.Hour {
+		t.Errorf("Unexpected time remaining %v", entry.TimeRemaining)
+	}
+}
+
+func TestCacheStats_JSON(t *testing.T) {
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	stats := CacheStats{
+		TotalCached: 1,
+		RenewBuffer: 5 * time.Minute,
+		CacheDetails: map[string]CacheEntryStats{
+			"67890": {InstallationID: "67890", ExpiresAt: expiresAt, TimeRemaining: 90 * time.Second},
+		},
+		Hits: 3,
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	// The keys of the map GetCacheStats used to return are kept
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if raw["total_cached"] != float64(1) || raw["renew_buffer"] != "5m0s" || raw["hits"] != float64(3) {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+	details, _ := raw["cache_details"].(map[string]interface{})
+	entry, _ := details["67890"].(map[string]interface{})
+	for _, key := range []string{"created_at", "last_used", "renewing", "expires_at", "is_expired"} {
+		if _, ok := entry[key]; !ok {
+			t.Errorf("Expected key %q in cache details: %s", key, data)
+		}
+	}
+	if entry["time_remaining"] != "1m30s" {
+		t.Errorf("Expected time_remaining 1m30s, got %v", entry["time_remaining"])
+	}
+
+	var decoded CacheStats
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if decoded.RenewBuffer != stats.RenewBuffer || decoded.CacheDetails["67890"].TimeRemaining != 90*time.Second ||
+		!decoded.CacheDetails["67890"].ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Round trip mismatch: %+v", decoded)
+	}
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is matched by errors.Is for every CircuitOpenError
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is returned without contacting GitHub while the circuit
+// breaker of an endpoint is open
+type CircuitOpenError struct {
+	// Endpoint is the host and route template the breaker guards
+	Endpoint string
+	// RetryAt is when a probe request will be let through again
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s for %s until %s", ErrCircuitOpen, e.Endpoint, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitBreakerConfig enables a circuit breaker per host and endpoint. After
+// FailureThreshold consecutive failed attempts the breaker opens and requests
+// fail fast with a *CircuitOpenError. Once OpenDuration has passed one probe
+// request is let through: its success closes the breaker and its failure
+// opens it again. Failures are attempts that got no response and responses
+// the RetryPolicy would retry.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker (default 5)
+	FailureThreshold int
+	// OpenDuration is how long the breaker stays open before probing (default 30s)
+	OpenDuration time.Duration
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker tracks the circuits of every endpoint. A nil breaker allows everything.
+type circuitBreaker struct {
+	failureThreshold int
+	openDuration     time.Duration
+
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+	now      func() time.Time
+}
+
+// circuit is the breaker state of one endpoint
+type circuit struct {
+	state    circuitState
+	failures int
+	retryAt  time.Time
+	probing  bool // A half-open probe is in flight
+}
+
+func newCircuitBreaker(config *CircuitBreakerConfig) *circuitBreaker {
+	if config == nil {
+		return nil
+	}
+
+	breaker := &circuitBreaker{
+		failureThreshold: config.FailureThreshold,
+		openDuration:     config.OpenDuration,
+		circuits:         make(map[string]*circuit),
+		now:              time.Now,
+	}
+	if breaker.failureThreshold <= 0 {
+		breaker.failureThreshold = 5
+	}
+	if breaker.openDuration <= 0 {
+		breaker.openDuration = 30 * time.Second
+	}
+
+	return breaker
+}
+
+// breakerKey identifies the endpoint of a request for the circuit breaker
+func breakerKey(config *RequestConfig, endpoint string) string {
+	if parsed, err := url.Parse(config.URL); err == nil {
+		return parsed.Host + endpoint
+	}
+	return endpoint
+}
+
+// allow returns a *CircuitOpenError when requests to key must fail fast
+func (b *circuitBreaker) allow(key string) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.circuits[key]
+	if c == nil || c.state == circuitClosed {
+		return nil
+	}
+
+	if c.state == circuitOpen && !b.now().Before(c.retryAt) {
+		c.state = circuitHalfOpen
+	}
+	if c.state == circuitHalfOpen && !c.probing {
+		c.probing = true
+		return nil
+	}
+
+	return &CircuitOpenError{Endpoint: key, RetryAt: c.retryAt}
+}
+
+// record counts the outcome of an attempt allowed for key and returns the
+// state of the circuit before and after it
+func (b *circuitBreaker) record(key string, failed bool) (before, after circuitState) {
+	if b == nil {
+		return circuitClosed, circuitClosed
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.circuits[key]
+	if c == nil {
+		if !failed {
+			return circuitClosed, circuitClosed
+		}
+		c = &circuit{}
+		b.circuits[key] = c
+	}
+
+	before = c.state
+	c.probing = false
+	switch {
+	case !failed:
+		c.state = circuitClosed
+		c.failures = 0
+	case c.state == circuitHalfOpen:
+		c.state = circuitOpen
+		c.retryAt = b.now().Add(b.openDuration)
+	default:
+		c.failures++
+		if c.failures >= b.failureThreshold {
+			c.state = circuitOpen
+			c.retryAt = b.now().Add(b.openDuration)
+		}
+	}
+
+	return before, c.state
+}
+
+// abandon releases a half-open probe whose outcome says nothing about GitHub,
+// such as a request canceled by its caller
+func (b *circuitBreaker) abandon(key string) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if c := b.circuits[key]; c != nil {
+		c.probing = false
+	}
+}
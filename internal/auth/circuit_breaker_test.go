@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ghappauth/internal/types"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker := newCircuitBreaker(&CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: time.Minute})
+	breaker.now = func() time.Time { return now }
+
+	const key = "api.github.com/app"
+
+	breaker.record(key, true)
+	if err := breaker.allow(key); err != nil {
+		t.Fatalf("Breaker should stay closed below the threshold, got %v", err)
+	}
+	if _, after := breaker.record(key, true); after != circuitOpen {
+		t.Fatalf("Expected the breaker to open, got %s", after)
+	}
+
+	err := breaker.allow(key)
+	var circuitErr *CircuitOpenError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &circuitErr) || !circuitErr.RetryAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Expected a CircuitOpenError until %v, got %v", now.Add(time.Minute), err)
+	}
+	if err := breaker.allow("api.github.com/meta"); err != nil {
+		t.Errorf("Other endpoints should not be affected, got %v", err)
+	}
+
+	// After the open duration a single probe is let through
+	now = now.Add(time.Minute)
+	if err := breaker.allow(key); err != nil {
+		t.Fatalf("Expected a probe to be allowed, got %v", err)
+	}
+	if err := breaker.allow(key); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected only one probe, got %v", err)
+	}
+
+	// A failed probe opens the breaker again
+	if _, after := breaker.record(key, true); after != circuitOpen {
+		t.Fatalf("Expected the breaker to reopen, got %s", after)
+	}
+	if err := breaker.allow(key); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected the breaker to be open, got %v", err)
+	}
+
+	// A successful probe closes it
+	now = now.Add(time.Minute)
+	if err := breaker.allow(key); err != nil {
+		t.Fatalf("Expected a probe to be allowed, got %v", err)
+	}
+	if before, after := breaker.record(key, false); before != circuitHalfOpen || after != circuitClosed {
+		t.Fatalf("Expected half-open to closed, got %s to %s", before, after)
+	}
+	if err := breaker.allow(key); err != nil {
+		t.Errorf("Expected the breaker to be closed, got %v", err)
+	}
+}
+
+func TestHTTPClient_CircuitBreaker(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(&HTTPClientConfig{
+		MaxRetries:     3,
+		RetryDelay:     time.Millisecond,
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: time.Minute},
+	})
+	request := &RequestConfig{Method: "GET", URL: server.URL + "/app", Endpoint: "/app"}
+
+	_, err := client.doRequest(context.Background(), request)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected the breaker to open during retries, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts before the breaker opened, got %d", attempts)
+	}
+
+	_, err = client.doRequest(context.Background(), request)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected no request while the breaker is open, got %d attempts", attempts)
+	}
+}
+
+func TestTokenManager_ServesCachedTokenWhileCircuitOpen(t *testing.T) {
+	requests := 0
+	expiresAt := time.Now().Add(2 * time.Minute)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{Token: "ghs_cached", ExpiresAt: expiresAt})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+	auth.httpClient = NewHTTPClient(&HTTPClientConfig{
+		MaxRetries:     1,
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Minute},
+	})
+
+	// The token is within the renew buffer as soon as it is minted
+	tm := NewTokenManager(auth, 5*time.Minute)
+	if _, err := tm.GetToken(); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	// The failed renewal opens the breaker
+	if _, err := tm.GetToken(); err == nil {
+		t.Fatal("Expected the renewal to fail")
+	}
+
+	token, err := tm.GetToken()
+	if err != nil {
+		t.Fatalf("Expected the cached token while the breaker is open, got %v", err)
+	}
+	if token.Token != "ghs_cached" {
+		t.Errorf("Expected the cached token, got %q", token.Token)
+	}
+	if requests != 2 {
+		t.Errorf("Expected no request while the breaker is open, got %d requests", requests)
+	}
+}
@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"ghappauth/internal/types"
+)
+
+const defaultBaseURL = "https://api.github.com"
+
+// Deployment identifies the kind of GitHub instance an App talks to
+type Deployment string
+
+const (
+	// DeploymentGitHubCom is github.com
+	DeploymentGitHubCom Deployment = "github.com"
+	// DeploymentGHEC is GitHub Enterprise Cloud with data residency on a ghe.com subdomain
+	DeploymentGHEC Deployment = "ghe.com"
+	// DeploymentGHES is a self-hosted GitHub Enterprise Server
+	DeploymentGHES Deployment = "ghes"
+	// DeploymentCustom is an API root that is not a known GitHub host, such as a proxy or a mock server
+	DeploymentCustom Deployment = "custom"
+)
+
+// Endpoints holds the URLs of the GitHub APIs for one deployment
+type Endpoints struct {
+	Deployment Deployment
+	WebURL     string
+	APIURL     string
+	UploadURL  string
+	GraphQLURL string
+}
+
+// ResolveEndpoints derives the REST, upload and GraphQL endpoints from a
+// configured base URL. github.com and ghe.com hosts are recognized from either
+// their web or their API URL. Any other base URL names the REST API root and is
+// used as given, apart from a trailing slash; one ending in /api/v3 is taken to
+// be GitHub Enterprise Server.
+//
+// deployment is normally empty. Set it to DeploymentGHES to pass the web URL of
+// a GitHub Enterprise Server host and have /api/v3 appended to it.
+func ResolveEndpoints(baseURL string, deployment Deployment) (*Endpoints, error) {
+	switch deployment {
+	case "", DeploymentGitHubCom, DeploymentGHEC, DeploymentGHES:
+	default:
+		return nil, fmt.Errorf("invalid deployment: %q, must be one of %s, %s or %s", deployment, DeploymentGitHubCom, DeploymentGHEC, DeploymentGHES)
+	}
+
+	baseURL = strings.TrimSpace(baseURL)
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base_url: %w", err)
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("invalid base_url: scheme must be http or https, got %q", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid base_url: host is required")
+	}
+
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid base_url: query and fragment are not allowed")
+	}
+
+	host := strings.ToLower(u.Host)
+	isGitHubCom := host == "github.com" || host == "api.github.com"
+	isGHEC := strings.HasSuffix(host, ".ghe.com")
+
+	switch {
+	case deployment == DeploymentGitHubCom && !isGitHubCom,
+		deployment == DeploymentGHEC && !isGHEC,
+		deployment == DeploymentGHES && (isGitHubCom || isGHEC):
+		return nil, fmt.Errorf("invalid base_url: %s is not a %s host", u.Host, deployment)
+	}
+
+	switch {
+	case isGitHubCom:
+		return &Endpoints{
+			Deployment: DeploymentGitHubCom,
+			WebURL:     "https://github.com",
+			APIURL:     "https://api.github.com",
+			UploadURL:  "https://uploads.github.com",
+			GraphQLURL: "https://api.github.com/graphql",
+		}, nil
+
+	case isGHEC:
+		subdomain := strings.TrimPrefix(strings.TrimSuffix(host, ".ghe.com"), "api.")
+		return &Endpoints{
+			Deployment: DeploymentGHEC,
+			WebURL:     fmt.Sprintf("https://%s.ghe.com", subdomain),
+			APIURL:     fmt.Sprintf("https://api.%s.ghe.com", subdomain),
+			UploadURL:  fmt.Sprintf("https://uploads.%s.ghe.com", subdomain),
+			GraphQLURL: fmt.Sprintf("https://api.%s.ghe.com/graphql", subdomain),
+		}, nil
+	}
+
+	origin := u.Scheme + "://" + u.Host
+	path := strings.TrimRight(u.Path, "/")
+
+	if deployment == DeploymentGHES || strings.HasSuffix(path, "/api/v3") {
+		// GitHub Enterprise Server serves the API under /api/v3 on the web host
+		path = strings.TrimSuffix(path, "/api/v3")
+		return &Endpoints{
+			Deployment: DeploymentGHES,
+			WebURL:     origin + path,
+			APIURL:     origin + path + "/api/v3",
+			UploadURL:  origin + path + "/api/uploads",
+			GraphQLURL: origin + path + "/api/graphql",
+		}, nil
+	}
+
+	return &Endpoints{
+		Deployment: DeploymentCustom,
+		WebURL:     origin,
+		APIURL:     origin + path,
+		UploadURL:  origin + path,
+		GraphQLURL: origin + path + "/graphql",
+	}, nil
+}
+
+// Feature is an API capability that is missing from older GitHub Enterprise Server versions
+type Feature string
+
+const (
+	// FeatureAPIVersions is support for the X-GitHub-Api-Version header
+	FeatureAPIVersions Feature = "api-versions"
+)
+
+// featureMinVersions lists the first GitHub Enterprise Server release supporting each feature
+var featureMinVersions = map[Feature]string{
+	FeatureAPIVersions: "3.9",
+}
+
+// ErrUnsupportedFeature is matched by errors.Is for every FeatureError
+var ErrUnsupportedFeature = errors.New("feature not supported by this GitHub Enterprise Server version")
+
+// FeatureError reports that the GitHub Enterprise Server version is too old for a feature
+type FeatureError struct {
+	Feature       Feature
+	MinVersion    string
+	ServerVersion string
+}
+
+func (e *FeatureError) Error() string {
+	return fmt.Sprintf("%s requires GitHub Enterprise Server %s or later (server is %s)", e.Feature, e.MinVersion, e.ServerVersion)
+}
+
+func (e *FeatureError) Is(target error) bool {
+	return target == ErrUnsupportedFeature
+}
+
+// ServerInfo describes the GitHub instance an App talks to
+type ServerInfo struct {
+	Deployment Deployment
+	// Version is the installed GitHub Enterprise Server version, empty for github.com and ghe.com
+	Version string
+}
+
+// Endpoints returns the API endpoints derived from the configured base URL
+func (g *GitHubAppAuth) Endpoints() Endpoints {
+	return *g.endpoints
+}
+
+// ServerInfo returns the deployment type and, for GitHub Enterprise Server,
+// the installed version. The version is probed once via GET /meta and cached.
+func (g *GitHubAppAuth) ServerInfo(ctx context.Context) (*ServerInfo, error) {
+	if g.endpoints.Deployment != DeploymentGHES {
+		return &ServerInfo{Deployment: g.endpoints.Deployment}, nil
+	}
+
+	g.serverInfoMutex.Lock()
+	defer g.serverInfoMutex.Unlock()
+
+	if g.serverInfo != nil {
+		info := *g.serverInfo
+		return &info, nil
+	}
+
+	var meta types.GitHubMeta
+	err := g.doJWTRequest(ctx, &RequestConfig{
+		Method:         "GET",
+		URL:            fmt.Sprintf("%s/meta", g.baseURL),
+		Endpoint:       "/meta",
+		ExpectedStatus: http.StatusOK,
+	}, &meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe server version: %w", err)
+	}
+
+	if meta.InstalledVersion == "" {
+		return nil, fmt.Errorf("failed to probe server version: response has no installed_version")
+	}
+
+	g.serverInfo = &ServerInfo{Deployment: DeploymentGHES, Version: meta.InstalledVersion}
+	info := *g.serverInfo
+	return &info, nil
+}
+
+// RequireFeature returns a FeatureError when the server is a GitHub Enterprise
+// Server release older than the first one supporting feature
+func (g *GitHubAppAuth) RequireFeature(ctx context.Context, feature Feature) error {
+	minVersion, ok := featureMinVersions[feature]
+	if !ok {
+		return fmt.Errorf("unknown feature: %s", feature)
+	}
+
+	info, err := g.ServerInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if info.Deployment != DeploymentGHES {
+		return nil
+	}
+
+	if compareVersions(info.Version, minVersion) < 0 {
+		return &FeatureError{Feature: feature, MinVersion: minVersion, ServerVersion: info.Version}
+	}
+
+	return nil
+}
+
+// compareVersions compares dotted numeric versions such as "3.9.2",
+// returning -1, 0 or 1. Non-numeric suffixes like "-rc1" are ignored.
+func compareVersions(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		x, y := versionPart(aParts, i), versionPart(bParts, i)
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// versionPart returns the numeric value of parts[i], or 0 when it is missing
+func versionPart(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+
+	digits := parts[i]
+	if end := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }); end >= 0 {
+		digits = digits[:end]
+	}
+
+	n, _ := strconv.Atoi(digits)
+	return n
+}
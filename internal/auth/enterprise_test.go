@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"ghappauth/internal/types"
+)
+
+func TestResolveEndpoints(t *testing.T) {
+	tests := []struct {
+		baseURL    string
+		deployment Deployment
+		want       Endpoints
+		wantErr    bool
+	}{
+		{
+			baseURL: "",
+			want:    Endpoints{DeploymentGitHubCom, "https://github.com", "https://api.github.com", "https://uploads.github.com", "https://api.github.com/graphql"},
+		},
+		{
+			baseURL: "https://api.github.com/",
+			want:    Endpoints{DeploymentGitHubCom, "https://github.com", "https://api.github.com", "https://uploads.github.com", "https://api.github.com/graphql"},
+		},
+		{
+			baseURL: "https://github.com",
+			want:    Endpoints{DeploymentGitHubCom, "https://github.com", "https://api.github.com", "https://uploads.github.com", "https://api.github.com/graphql"},
+		},
+		{
+			baseURL: "https://octocorp.ghe.com",
+			want:    Endpoints{DeploymentGHEC, "https://octocorp.ghe.com", "https://api.octocorp.ghe.com", "https://uploads.octocorp.ghe.com", "https://api.octocorp.ghe.com/graphql"},
+		},
+		{
+			baseURL: "https://api.octocorp.ghe.com/",
+			want:    Endpoints{DeploymentGHEC, "https://octocorp.ghe.com", "https://api.octocorp.ghe.com", "https://uploads.octocorp.ghe.com", "https://api.octocorp.ghe.com/graphql"},
+		},
+		{
+			baseURL: "https://github.example.com/api/v3/",
+			want:    Endpoints{DeploymentGHES, "https://github.example.com", "https://github.example.com/api/v3", "https://github.example.com/api/uploads", "https://github.example.com/api/graphql"},
+		},
+		{
+			baseURL:    "https://github.example.com:8443/",
+			deployment: DeploymentGHES,
+			want:       Endpoints{DeploymentGHES, "https://github.example.com:8443", "https://github.example.com:8443/api/v3", "https://github.example.com:8443/api/uploads", "https://github.example.com:8443/api/graphql"},
+		},
+		{
+			baseURL:    "https://github.example.com/api/v3",
+			deployment: DeploymentGHES,
+			want:       Endpoints{DeploymentGHES, "https://github.example.com", "https://github.example.com/api/v3", "https://github.example.com/api/uploads", "https://github.example.com/api/graphql"},
+		},
+		{
+			baseURL: "http://localhost:8080",
+			want:    Endpoints{DeploymentCustom, "http://localhost:8080", "http://localhost:8080", "http://localhost:8080", "http://localhost:8080/graphql"},
+		},
+		{
+			baseURL: "https://proxy.example.com/github/",
+			want:    Endpoints{DeploymentCustom, "https://proxy.example.com", "https://proxy.example.com/github", "https://proxy.example.com/github", "https://proxy.example.com/github/graphql"},
+		},
+		{baseURL: "https://github.example.com", deployment: DeploymentGitHubCom, wantErr: true},
+		{baseURL: "https://github.example.com", deployment: DeploymentGHEC, wantErr: true},
+		{baseURL: "https://api.github.com", deployment: DeploymentGHES, wantErr: true},
+		{baseURL: "https://github.example.com", deployment: "enterprise", wantErr: true},
+		{baseURL: "github.example.com", wantErr: true},
+		{baseURL: "ftp://github.example.com", wantErr: true},
+		{baseURL: "https://", wantErr: true},
+		{baseURL: "https://api.github.com?x=1", wantErr: true},
+		{baseURL: "://bad", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.deployment)+" "+tt.baseURL, func(t *testing.T) {
+			endpoints, err := ResolveEndpoints(tt.baseURL, tt.deployment)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveEndpoints(%q, %q) error = %v, wantErr %v", tt.baseURL, tt.deployment, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if *endpoints != tt.want {
+				t.Errorf("ResolveEndpoints(%q, %q) = %+v, want %+v", tt.baseURL, tt.deployment, *endpoints, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_BaseURL(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		wantErr bool
+	}{
+		{"https://api.github.com", false},
+		{"https://github.example.com/api/v3", false},
+		{"http://localhost:8080", false},
+		{"github.example.com", true},
+		{"ftp://github.example.com", true},
+		{"https://", true},
+		{"https://api.github.com?x=1", true},
+		{"://bad", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.baseURL, func(t *testing.T) {
+			errs := ValidateConfig(&types.GitHubAppConfig{
+				AppID:          "12345",
+				PrivateKey:     testPrivateKey,
+				InstallationID: "67890",
+				BaseURL:        tt.baseURL,
+			})
+			if (len(errs) > 0) != tt.wantErr {
+				t.Errorf("ValidateConfig() with base_url %q = %v, wantErr %v", tt.baseURL, errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"3.9", "3.9", 0},
+		{"3.9.0", "3.9", 0},
+		{"3.10.1", "3.9", 1},
+		{"3.8.12", "3.9", -1},
+		{"2.22.0", "3.0", -1},
+		{"3.9.0-rc1", "3.9", 0},
+	}
+
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestGitHubAppAuth_RequireFeature(t *testing.T) {
+	var probes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/meta" {
+			t.Errorf("Expected path /api/v3/meta, got %s", r.URL.Path)
+		}
+		atomic.AddInt32(&probes, 1)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"installed_version": "3.8.4"}`))
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+		Deployment:     string(DeploymentGHES),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	info, err := auth.ServerInfo(context.Background())
+	if err != nil {
+		t.Fatalf("ServerInfo() error = %v", err)
+	}
+	if info.Deployment != DeploymentGHES || info.Version != "3.8.4" {
+		t.Errorf("Expected GHES 3.8.4, got %+v", info)
+	}
+
+	err = auth.RequireFeature(context.Background(), FeatureAPIVersions)
+	if !errors.Is(err, ErrUnsupportedFeature) {
+		t.Fatalf("Expected ErrUnsupportedFeature, got %v", err)
+	}
+	var featureErr *FeatureError
+	if !errors.As(err, &featureErr) || featureErr.MinVersion != "3.9" {
+		t.Errorf("Expected FeatureError with min version 3.9, got %v", err)
+	}
+
+	if atomic.LoadInt32(&probes) != 1 {
+		t.Errorf("Expected server version to be probed once, got %d", atomic.LoadInt32(&probes))
+	}
+
+	if err := auth.RequireFeature(context.Background(), Feature("unknown")); err == nil {
+		t.Error("RequireFeature() should fail for unknown features")
+	}
+}
+
+func TestGitHubAppAuth_ServerInfo_Custom(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	if endpoints := auth.Endpoints(); endpoints.APIURL != server.URL {
+		t.Errorf("Expected API URL %s, got %s", server.URL, endpoints.APIURL)
+	}
+
+	info, err := auth.ServerInfo(context.Background())
+	if err != nil {
+		t.Fatalf("ServerInfo() error = %v", err)
+	}
+	if info.Deployment != DeploymentCustom || info.Version != "" {
+		t.Errorf("Expected a custom deployment without a version, got %+v", info)
+	}
+}
+
+func TestGitHubAppAuth_RequireFeature_GitHubCom(t *testing.T) {
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	if err := auth.RequireFeature(context.Background(), FeatureAPIVersions); err != nil {
+		t.Errorf("RequireFeature() on github.com error = %v", err)
+	}
+	if endpoints := auth.Endpoints(); endpoints.Deployment != DeploymentGitHubCom {
+		t.Errorf("Expected github.com deployment, got %s", endpoints.Deployment)
+	}
+}
@@ -1,138 +1,539 @@
 package auth
 
 import (
+	"bytes"
 	"context"
 	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel/trace"
+	"ghappauth/internal/audit"
+	"ghappauth/internal/metrics"
 	"ghappauth/internal/types"
 )
 
+const (
+	// jwtLifetime is how long a generated JWT is valid; GitHub allows at most 10 minutes
+	jwtLifetime = 10 * time.Minute
+	// defaultJWTRefreshMargin is how long before expiry a cached JWT is replaced
+	defaultJWTRefreshMargin = 1 * time.Minute
+)
+
 // GitHubAppAuth handles GitHub App authentication
 type GitHubAppAuth struct {
 	config     *types.GitHubAppConfig
-	privateKey *rsa.PrivateKey
+	keys       *keyRing
 	baseURL    string
+	endpoints  *Endpoints
 	httpClient *HTTPClient
+	logger     *slog.Logger
+	metrics    metrics.Recorder
+	tracer     trace.Tracer
+	clock      func() time.Time
+
+	serverInfoMutex sync.Mutex
+	serverInfo      *ServerInfo // Cached result of the GHES version probe
+
+	hooksMutex  sync.RWMutex
+	tokenPolicy TokenPolicy // Checked before any installation token is created
+	auditSink   audit.Sink  // Receives token lifecycle events
+
+	jwtMutex         sync.RWMutex
+	cachedJWT        string
+	jwtExpiresAt     time.Time
+	jwtKeyVersion    uint64        // Key ring version the cached JWT was signed with
+	jwtRefreshMargin time.Duration // How much time before expiry to sign a new JWT
+
+	keyWatched atomic.Bool // WatchPrivateKey owns the primary key
 }
 
-// NewGitHubAppAuth creates a new GitHub App authentication instance
+// NewGitHubAppAuth creates a new GitHub App authentication instance. It is
+// equivalent to New without options.
 func NewGitHubAppAuth(config *types.GitHubAppConfig) (*GitHubAppAuth, error) {
+	return New(config)
+}
+
+// NewAppAuth creates a GitHub App authentication instance for App-level
+// endpoints. Unlike NewGitHubAppAuth the installation_id is optional; methods
+// that act on the configured installation fail when it is not set. It is
+// equivalent to NewApp without options.
+func NewAppAuth(config *types.GitHubAppConfig) (*GitHubAppAuth, error) {
+	return NewApp(config)
+}
+
+func newGitHubAppAuth(config *types.GitHubAppConfig, requireInstallation bool, opts *options) (*GitHubAppAuth, error) {
 	if config == nil {
 		return nil, fmt.Errorf("config cannot be nil")
 	}
 
-	if config.AppID == "" {
-		return nil, fmt.Errorf("app_id is required")
+	// Report every problem at once, as ValidateConfig does
+	errs := validateConfigFields(config, requireInstallation)
+	var privateKeys []*rsa.PrivateKey
+	if config.PrivateKey != "" {
+		var err error
+		privateKeys, err = loadPrivateKeys(config.PrivateKeyPassphrase, config.PrivateKey, config.FallbackPrivateKeys...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
 	}
 
-	if config.PrivateKey == "" {
-		return nil, fmt.Errorf("private_key is required")
+	endpoints, err := ResolveEndpoints(config.BaseURL, Deployment(config.Deployment))
+	if err != nil {
+		return nil, err
 	}
 
-	if config.InstallationID == "" {
-		return nil, fmt.Errorf("installation_id is required")
+	g := &GitHubAppAuth{
+		config:     config,
+		keys:       newKeyRing(privateKeys),
+		baseURL:    endpoints.APIURL,
+		endpoints:  endpoints,
+		httpClient: NewHTTPClient(nil),
+		logger:     discardLogger,
+		metrics:    metrics.Discard,
+		tracer:     noopTracer,
+		clock:      time.Now,
+
+		jwtRefreshMargin: defaultJWTRefreshMargin,
 	}
+	opts.apply(g)
 
-	_, err := strconv.Atoi(config.AppID)
-	if err != nil {
-		return nil, fmt.Errorf("invalid app_id: %w", err)
+	return g, nil
+}
+
+// ValidationError reports every problem found in a configuration
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("invalid configuration: %v", e.Errors[0])
 	}
 
-	_, err = strconv.Atoi(config.InstallationID)
-	if err != nil {
-		return nil, fmt.Errorf("invalid installation_id: %w", err)
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration (%d errors):", len(e.Errors))
+	for _, err := range e.Errors {
+		fmt.Fprintf(&b, "\n  - %v", err)
 	}
+	return b.String()
+}
 
-	privateKey, err := parsePrivateKey(config.PrivateKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
+func (e *ValidationError) Unwrap() []error {
+	return e.Errors
+}
+
+// ValidateConfig checks config the same way NewGitHubAppAuth does, including
+// loading the private keys, but reports every problem instead of stopping at the first one
+func ValidateConfig(config *types.GitHubAppConfig) []error {
+	return validateConfig(config, true)
+}
+
+// ValidateAppConfig checks config the same way NewAppAuth does, reporting every problem
+func ValidateAppConfig(config *types.GitHubAppConfig) []error {
+	return validateConfig(config, false)
+}
+
+func validateConfig(config *types.GitHubAppConfig, requireInstallation bool) []error {
+	if config == nil {
+		return []error{fmt.Errorf("config cannot be nil")}
 	}
 
-	baseURL := config.BaseURL
-	if baseURL == "" {
-		baseURL = "https://api.github.com"
+	errs := validateConfigFields(config, requireInstallation)
+	if config.PrivateKey != "" {
+		if _, err := loadPrivateKeys(config.PrivateKeyPassphrase, config.PrivateKey, config.FallbackPrivateKeys...); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	return &GitHubAppAuth{
-		config:     config,
-		privateKey: privateKey,
-		baseURL:    baseURL,
-		httpClient: NewHTTPClient(nil),
-	}, nil
+	return errs
 }
 
-// parsePrivateKey parses a PEM-encoded RSA private key
-func parsePrivateKey(privateKeyPEM string) (*rsa.PrivateKey, error) {
-	block, _ := pem.Decode([]byte(privateKeyPEM))
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode PEM block")
+// validateConfigFields checks the required config fields and their format
+func validateConfigFields(config *types.GitHubAppConfig, requireInstallation bool) []error {
+	var errs []error
+
+	if config.AppID == "" {
+		errs = append(errs, fmt.Errorf("app_id is required"))
 	}
 
-	var privateKey *rsa.PrivateKey
-	var err error
+	if config.PrivateKey == "" {
+		errs = append(errs, fmt.Errorf("private_key is required"))
+	}
 
-	switch block.Type {
-	case "RSA PRIVATE KEY":
-		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
-	case "PRIVATE KEY":
-		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse PKCS8 private key: %w", err)
+	if config.InstallationID == "" && requireInstallation {
+		errs = append(errs, fmt.Errorf("installation_id is required"))
+	}
+
+	if config.AppID != "" {
+		if _, err := strconv.Atoi(config.AppID); err != nil {
+			errs = append(errs, fmt.Errorf("invalid app_id: %w", err))
 		}
-		var ok bool
-		privateKey, ok = key.(*rsa.PrivateKey)
-		if !ok {
-			return nil, fmt.Errorf("key is not an RSA private key")
+	}
+
+	if config.InstallationID != "" {
+		if _, err := strconv.Atoi(config.InstallationID); err != nil {
+			errs = append(errs, fmt.Errorf("invalid installation_id: %w", err))
 		}
-	default:
-		return nil, fmt.Errorf("unsupported private key type: %s", block.Type)
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse RSA private key: %w", err)
+	if _, err := ResolveEndpoints(config.BaseURL, Deployment(config.Deployment)); err != nil {
+		errs = append(errs, err)
 	}
 
-	return privateKey, nil
+	return errs
 }
 
-// GenerateJWT generates a JWT token for GitHub App authentication
+// loadPrivateKeys resolves and parses the primary key followed by any fallback keys
+func loadPrivateKeys(passphrase string, primary string, fallbacks ...string) ([]*rsa.PrivateKey, error) {
+	refs := append([]string{primary}, fallbacks...)
+	keys := make([]*rsa.PrivateKey, 0, len(refs))
+
+	for i, ref := range refs {
+		name := "private key"
+		if i > 0 {
+			name = fmt.Sprintf("fallback private key %d", i)
+		}
+
+		privateKeyPEM, err := ResolvePrivateKey(context.Background(), ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", name, err)
+		}
+
+		privateKey, err := parsePrivateKey(privateKeyPEM, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+
+		keys = append(keys, privateKey)
+	}
+
+	return keys, nil
+}
+
+// AppID returns the configured GitHub App ID
+func (g *GitHubAppAuth) AppID() string {
+	return g.config.AppID
+}
+
+// InstallationID returns the configured installation ID, which may be empty for NewAppAuth
+func (g *GitHubAppAuth) InstallationID() string {
+	return g.config.InstallationID
+}
+
+// GenerateJWT generates a new JWT token for GitHub App authentication.
+// Use GetJWT to reuse a cached token instead of signing a new one on every call.
 func (g *GitHubAppAuth) GenerateJWT() (string, error) {
-	now := time.Now()
+	signed, _, _, err := g.signJWT(context.Background())
+	return signed, err
+}
+
+// signJWT signs a new JWT with the active key and returns it together with
+// its expiry time and the key ring version used to sign it
+func (g *GitHubAppAuth) signJWT(ctx context.Context) (_ string, _ time.Time, _ uint64, err error) {
+	_, span := g.tracer.Start(ctx, "GitHubAppAuth.GenerateJWT", trace.WithAttributes(attrAppID.String(g.config.AppID)))
+	defer func() { endSpan(span, err) }()
+
+	now := g.clock()
+	expiresAt := now.Add(jwtLifetime)
 	claims := jwt.RegisteredClaims{
 		Issuer:    g.config.AppID,
 		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(now.Add(10 * time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
 		Subject:   g.config.AppID,
 	}
 
+	privateKey, version := g.keys.current()
+
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(g.privateKey)
+	signed, err := token.SignedString(privateKey)
+	if err != nil {
+		return "", time.Time{}, 0, err
+	}
+
+	return signed, expiresAt, version, nil
+}
+
+// GetJWT returns a cached JWT, signing a new one only when the cached token
+// is missing or within the refresh margin of its expiry
+func (g *GitHubAppAuth) GetJWT() (string, error) {
+	signed, _, err := g.getJWT(context.Background())
+	return signed, err
+}
+
+// getJWT returns a cached or newly signed JWT together with the key ring
+// version it was signed with
+func (g *GitHubAppAuth) getJWT(ctx context.Context) (string, uint64, error) {
+	g.jwtMutex.RLock()
+	if g.isJWTValid() {
+		cached, version := g.cachedJWT, g.jwtKeyVersion
+		g.jwtMutex.RUnlock()
+		return cached, version, nil
+	}
+	g.jwtMutex.RUnlock()
+
+	g.jwtMutex.Lock()
+	defer g.jwtMutex.Unlock()
+
+	// Another goroutine may have refreshed the token while we waited for the lock
+	if g.isJWTValid() {
+		return g.cachedJWT, g.jwtKeyVersion, nil
+	}
+
+	signed, expiresAt, version, err := g.signJWT(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+
+	g.cachedJWT = signed
+	g.jwtExpiresAt = expiresAt
+	g.jwtKeyVersion = version
+
+	g.logger.DebugContext(ctx, "signed app JWT", "app_id", g.config.AppID, "expires_at", expiresAt)
+	return signed, version, nil
+}
+
+// isJWTValid reports whether the cached JWT can be reused; callers must hold jwtMutex
+func (g *GitHubAppAuth) isJWTValid() bool {
+	return g.cachedJWT != "" &&
+		g.jwtKeyVersion == g.keys.currentVersion() &&
+		g.clock().Add(g.jwtRefreshMargin).Before(g.jwtExpiresAt)
+}
+
+// InvalidateJWT discards the cached JWT, forcing a new one to be signed on next use
+func (g *GitHubAppAuth) InvalidateJWT() {
+	g.jwtMutex.Lock()
+	g.cachedJWT = ""
+	g.jwtExpiresAt = time.Time{}
+	g.jwtMutex.Unlock()
+}
+
+// SetJWTRefreshMargin sets how long before expiry a cached JWT is replaced
+func (g *GitHubAppAuth) SetJWTRefreshMargin(margin time.Duration) {
+	g.jwtMutex.Lock()
+	g.jwtRefreshMargin = margin
+	g.jwtMutex.Unlock()
+}
+
+// GetJWTRefreshMargin returns the current JWT refresh margin
+func (g *GitHubAppAuth) GetJWTRefreshMargin() time.Duration {
+	g.jwtMutex.RLock()
+	defer g.jwtMutex.RUnlock()
+	return g.jwtRefreshMargin
+}
+
+// PrivateKeyFile returns the path of the private key file when the key is
+// configured as a file:// reference, the only kind WatchPrivateKey applies to
+func (g *GitHubAppAuth) PrivateKeyFile() (string, bool) {
+	return keyFilePath(g.config.PrivateKey)
+}
+
+// WatchPrivateKey polls the private key file every interval and swaps in the
+// new key when its contents change, so a key rotated on disk is picked up
+// without a restart. It only applies to keys configured as file:// references
+// and stops when ctx is done. Read and parse failures are logged as warnings
+// and counted in the key reload metric, and signing continues with the
+// current key. A file that fails to parse is not parsed again until its
+// contents change.
+//
+// While the file is watched it is the only source of the primary key:
+// SetPrivateKeys fails until ctx is done, so a reload never silently
+// overwrites keys set at runtime.
+func (g *GitHubAppAuth) WatchPrivateKey(ctx context.Context, interval time.Duration) error {
+	path, ok := g.PrivateKeyFile()
+	if !ok {
+		return fmt.Errorf("private key is not loaded from a file")
+	}
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	last, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	if !g.keyWatched.CompareAndSwap(false, true) {
+		return fmt.Errorf("private key file is already watched")
+	}
+
+	go func() {
+		defer g.keyWatched.Store(false)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				current, err := os.ReadFile(path)
+				if err != nil {
+					g.metrics.KeyReloaded(err)
+					g.logger.WarnContext(ctx, "failed to read private key file, keeping the current key",
+						"path", path, "error", err)
+					continue
+				}
+				if bytes.Equal(current, last) {
+					continue
+				}
+				last = current
+
+				err = g.reloadPrivateKey(string(current))
+				g.metrics.KeyReloaded(err)
+				if err != nil {
+					g.logger.WarnContext(ctx, "failed to reload private key, keeping the current key",
+						"path", path, "error", err)
+					continue
+				}
+				g.logger.InfoContext(ctx, "reloaded private key", "path", path)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// reloadPrivateKey parses a new private key and makes it the signing key
+func (g *GitHubAppAuth) reloadPrivateKey(privateKeyPEM string) error {
+	privateKey, err := parsePrivateKey(privateKeyPEM, g.config.PrivateKeyPassphrase)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	g.keys.replacePrimary(privateKey)
+
+	return nil
+}
+
+// SetPrivateKeys hot-swaps the signing keys at runtime. The primary key signs
+// new JWTs and the fallbacks are tried in order when GitHub rejects it. Keys
+// may be given as PEM or as any reference accepted by ResolvePrivateKey. It
+// fails while WatchPrivateKey is watching the key file.
+func (g *GitHubAppAuth) SetPrivateKeys(primary string, fallbacks ...string) error {
+	if g.keyWatched.Load() {
+		return fmt.Errorf("private key is watched from a file; stop WatchPrivateKey before setting keys")
+	}
+
+	privateKeys, err := loadPrivateKeys(g.config.PrivateKeyPassphrase, primary, fallbacks...)
+	if err != nil {
+		return err
+	}
+
+	g.keys.replace(privateKeys)
+
+	return nil
+}
+
+// ActiveKeyIndex returns the position of the key currently used to sign JWTs,
+// 0 being the primary key and 1 onwards the fallback keys in order
+func (g *GitHubAppAuth) ActiveKeyIndex() int {
+	return g.keys.activeIndex()
+}
+
+// ResetActiveKey makes the primary key sign JWTs again after GitHub rejected
+// it and a fallback key took over, for example once the primary key has been
+// registered with the App again
+func (g *GitHubAppAuth) ResetActiveKey() {
+	g.keys.reset()
+}
+
+// doJWTRequest performs a JWT-authenticated request. When GitHub answers 401
+// "Bad credentials" the next configured private key is tried, so a key that
+// was revoked during rotation does not cause an outage while a fallback key is
+// still valid. Other 401s, such as JWT claims rejected because of clock skew,
+// are returned without changing keys.
+func (g *GitHubAppAuth) doJWTRequest(ctx context.Context, config *RequestConfig, result interface{}) error {
+	for {
+		jwt, version, err := g.getJWT(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to generate JWT: %w", err)
+		}
+
+		config.AuthToken = jwt
+		config.CacheCredential = "app:" + g.config.AppID
+		err = g.httpClient.DoRequest(ctx, config, result)
+		if err == nil || !isBadCredentials(err) {
+			return err
+		}
+
+		if !g.keys.advance(version) {
+			return err
+		}
+		g.logger.WarnContext(ctx, "GitHub rejected the app JWT, trying the next private key",
+			"app_id", g.config.AppID, "key_index", g.keys.activeIndex())
+	}
+}
+
+// isBadCredentials reports whether err is a 401 "Bad credentials" response
+// from the GitHub API, meaning the key that signed the JWT is not registered
+func isBadCredentials(err error) bool {
+	var apiError *APIError
+	return errors.As(err, &apiError) &&
+		apiError.StatusCode == http.StatusUnauthorized &&
+		strings.Contains(strings.ToLower(apiError.Message), "bad credentials")
 }
 
 // GetInstallationToken retrieves an installation access token from GitHub
 func (g *GitHubAppAuth) GetInstallationToken() (*types.GitHubAppToken, error) {
-	jwt, err := g.GenerateJWT()
+	if g.config.InstallationID == "" {
+		return nil, fmt.Errorf("installation_id is required")
+	}
+
+	return g.CreateInstallationToken(context.Background(), g.config.InstallationID, nil)
+}
+
+// CreateInstallationToken retrieves an installation access token for the given
+// installation. A non-nil request narrows the token to specific repositories
+// or permissions. The request is checked against the token policy, if one is
+// set, before it is sent to GitHub.
+func (g *GitHubAppAuth) CreateInstallationToken(ctx context.Context, installationID string, request *types.InstallationTokenRequest) (*types.GitHubAppToken, error) {
+	if err := g.AuthorizeToken(ctx, installationID, request); err != nil {
+		return nil, err
+	}
+
+	token, err := g.createInstallationToken(ctx, installationID, request)
+	g.metrics.TokenMinted(err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate JWT: %w", err)
+		return nil, err
 	}
 
-	url := fmt.Sprintf("%s/app/installations/%s/access_tokens", g.baseURL, g.config.InstallationID)
-	
-	var tokenResponse types.InstallationTokenResponse
-	err = g.httpClient.DoRequest(context.Background(), &RequestConfig{
-		Method:        "POST",
-		URL:           url,
-		AuthToken:     jwt,
+	g.logger.InfoContext(ctx, "minted installation token", tokenLogAttrs(installationID, token)...)
+	g.emit(ctx, tokenEvent(audit.EventMint, installationID, request, token))
+	return token, nil
+}
+
+// createInstallationToken requests an installation token without consulting the token policy
+func (g *GitHubAppAuth) createInstallationToken(ctx context.Context, installationID string, request *types.InstallationTokenRequest) (*types.GitHubAppToken, error) {
+	url := fmt.Sprintf("%s/app/installations/%s/access_tokens", g.baseURL, installationID)
+
+	requestConfig := &RequestConfig{
+		Method:         "POST",
+		URL:            url,
+		Endpoint:       "/app/installations/{installation_id}/access_tokens",
+		RetrySafe:      true, // A repeated request only mints another token
 		ExpectedStatus: http.StatusCreated,
-	}, &tokenResponse)
-	
+	}
+	if request != nil {
+		requestConfig.JSONBody = request
+	}
+
+	var tokenResponse types.InstallationTokenResponse
+	err := g.doJWTRequest(ctx, requestConfig, &tokenResponse)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get installation token: %w", err)
 	}
@@ -146,23 +547,58 @@ func (g *GitHubAppAuth) GetInstallationToken() (*types.GitHubAppToken, error) {
 	}, nil
 }
 
-// GetAppInfo retrieves information about the GitHub App
-func (g *GitHubAppAuth) GetAppInfo() (*types.GitHubApp, error) {
-	jwt, err := g.GenerateJWT()
+// RevokeInstallationToken revokes an installation access token so it can no longer be used
+func (g *GitHubAppAuth) RevokeInstallationToken(ctx context.Context, token string) error {
+	if err := revokeToken(ctx, g.httpClient, g.baseURL, token); err != nil {
+		return err
+	}
+
+	g.emit(ctx, audit.Event{Type: audit.EventRevoke, Fingerprint: audit.Fingerprint(token)})
+	return nil
+}
+
+// RevokeToken revokes an installation access token without App credentials,
+// since GitHub authenticates the request with the token itself. baseURL and
+// deployment are resolved as by ResolveEndpoints.
+func RevokeToken(ctx context.Context, baseURL string, deployment Deployment, token string) error {
+	endpoints, err := ResolveEndpoints(baseURL, deployment)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate JWT: %w", err)
+		return err
 	}
 
+	return revokeToken(ctx, NewHTTPClient(nil), endpoints.APIURL, token)
+}
+
+func revokeToken(ctx context.Context, client *HTTPClient, apiURL, token string) error {
+	err := client.DoRequest(ctx, &RequestConfig{
+		Method:         "DELETE",
+		URL:            fmt.Sprintf("%s/installation/token", apiURL),
+		Endpoint:       "/installation/token",
+		AuthToken:      token,
+		ExpectedStatus: http.StatusNoContent,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to revoke installation token: %w", err)
+	}
+	return nil
+}
+
+// GetAppInfo retrieves information about the GitHub App
+func (g *GitHubAppAuth) GetAppInfo() (*types.GitHubApp, error) {
+	return g.AppInfo(context.Background())
+}
+
+// AppInfo is GetAppInfo with a context for cancellation and tracing
+func (g *GitHubAppAuth) AppInfo(ctx context.Context) (*types.GitHubApp, error) {
 	url := fmt.Sprintf("%s/app", g.baseURL)
-	
+
 	var app types.GitHubApp
-	err = g.httpClient.DoRequest(context.Background(), &RequestConfig{
-		Method:        "GET",
-		URL:           url,
-		AuthToken:     jwt,
+	err := g.doJWTRequest(ctx, &RequestConfig{
+		Method:         "GET",
+		URL:            url,
+		Endpoint:       "/app",
 		ExpectedStatus: http.StatusOK,
 	}, &app)
-	
 	if err != nil {
 		return nil, fmt.Errorf("failed to get app info: %w", err)
 	}
@@ -172,21 +608,24 @@ func (g *GitHubAppAuth) GetAppInfo() (*types.GitHubApp, error) {
 
 // GetInstallation retrieves information about the configured installation
 func (g *GitHubAppAuth) GetInstallation() (*types.GitHubAppInstallation, error) {
-	jwt, err := g.GenerateJWT()
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate JWT: %w", err)
+	return g.Installation(context.Background())
+}
+
+// Installation is GetInstallation with a context for cancellation and tracing
+func (g *GitHubAppAuth) Installation(ctx context.Context) (*types.GitHubAppInstallation, error) {
+	if g.config.InstallationID == "" {
+		return nil, fmt.Errorf("installation_id is required")
 	}
 
-	url := fmt.Sprintf("%s/app/installations/%s", g.baseURL, g.config.InstallationID)
-	
+	requestURL := fmt.Sprintf("%s/app/installations/%s", g.baseURL, g.config.InstallationID)
+
 	var installation types.GitHubAppInstallation
-	err = g.httpClient.DoRequest(context.Background(), &RequestConfig{
-		Method:        "GET",
-		URL:           url,
-		AuthToken:     jwt,
+	err := g.doJWTRequest(ctx, &RequestConfig{
+		Method:         "GET",
+		URL:            requestURL,
+		Endpoint:       "/app/installations/{installation_id}",
 		ExpectedStatus: http.StatusOK,
 	}, &installation)
-	
 	if err != nil {
 		return nil, fmt.Errorf("failed to get installation: %w", err)
 	}
@@ -194,4 +633,55 @@ func (g *GitHubAppAuth) GetInstallation() (*types.GitHubAppInstallation, error)
 	return &installation, nil
 }
 
- 
\ No newline at end of file
+// installationsPerPage is the page size used when listing installations
+const installationsPerPage = 100
+
+// ListInstallations retrieves every installation of the GitHub App
+func (g *GitHubAppAuth) ListInstallations(ctx context.Context) ([]types.GitHubAppInstallation, error) {
+	var installations []types.GitHubAppInstallation
+
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/app/installations?per_page=%d&page=%d", g.baseURL, installationsPerPage, page)
+
+		var pageInstallations []types.GitHubAppInstallation
+		err := g.doJWTRequest(ctx, &RequestConfig{
+			Method:         "GET",
+			URL:            url,
+			Endpoint:       "/app/installations",
+			ExpectedStatus: http.StatusOK,
+		}, &pageInstallations)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list installations: %w", err)
+		}
+
+		installations = append(installations, pageInstallations...)
+		if len(pageInstallations) < installationsPerPage {
+			return installations, nil
+		}
+	}
+}
+
+// GetRepositoryInstallation retrieves the App installation that has access to a repository
+func (g *GitHubAppAuth) GetRepositoryInstallation(ctx context.Context, owner, repo string) (*types.GitHubAppInstallation, error) {
+	// owner and repo come from callers such as git and socket clients; keep
+	// them to one path segment each so they cannot reach another endpoint
+	for _, segment := range []string{owner, repo} {
+		if segment == "" || segment == "." || segment == ".." || strings.Contains(segment, "/") {
+			return nil, fmt.Errorf("invalid repository %q", owner+"/"+repo)
+		}
+	}
+	requestURL := fmt.Sprintf("%s/repos/%s/%s/installation", g.baseURL, url.PathEscape(owner), url.PathEscape(repo))
+
+	var installation types.GitHubAppInstallation
+	err := g.doJWTRequest(ctx, &RequestConfig{
+		Method:         "GET",
+		URL:            requestURL,
+		Endpoint:       "/repos/{owner}/{repo}/installation",
+		ExpectedStatus: http.StatusOK,
+	}, &installation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get installation for %s/%s: %w", owner, repo, err)
+	}
+
+	return &installation, nil
+}
@@ -1,9 +1,24 @@
 package auth
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"ghappauth/internal/audit"
+	"ghappauth/internal/metrics"
+	"ghappauth/internal/policy"
 	"ghappauth/internal/types"
 )
 
@@ -188,6 +203,26 @@ func TestNewGitHubAppAuth(t *testing.T) {
 	}
 }
 
+func TestNewGitHubAppAuth_ReportsEveryError(t *testing.T) {
+	_, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "not-a-number",
+		InstallationID: "also-not-a-number",
+	})
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+	if len(validationErr.Errors) != 3 {
+		t.Errorf("Expected 3 errors, got %v", validationErr.Errors)
+	}
+	for _, want := range []string{"private_key is required", "invalid app_id", "invalid installation_id"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %q", want, err.Error())
+		}
+	}
+}
+
 func TestGitHubAppAuth_GenerateJWT(t *testing.T) {
 	config := &types.GitHubAppConfig{
 		AppID:          "12345",
@@ -217,6 +252,134 @@ func TestGitHubAppAuth_GenerateJWT(t *testing.T) {
 	}
 }
 
+func TestGitHubAppAuth_GetJWT(t *testing.T) {
+	config := &types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+	}
+
+	auth, err := NewGitHubAppAuth(config)
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	if auth.GetJWTRefreshMargin() != defaultJWTRefreshMargin {
+		t.Errorf("Expected default refresh margin of %v, got %v", defaultJWTRefreshMargin, auth.GetJWTRefreshMargin())
+	}
+
+	first, err := auth.GetJWT()
+	if err != nil {
+		t.Fatalf("GetJWT() error = %v", err)
+	}
+
+	second, err := auth.GetJWT()
+	if err != nil {
+		t.Fatalf("GetJWT() error = %v", err)
+	}
+	if first != second {
+		t.Error("GetJWT() should reuse the cached token")
+	}
+
+	// Move the cached token inside the refresh margin
+	auth.jwtMutex.Lock()
+	auth.jwtExpiresAt = time.Now().Add(30 * time.Second)
+	auth.jwtMutex.Unlock()
+
+	third, err := auth.GetJWT()
+	if err != nil {
+		t.Fatalf("GetJWT() error = %v", err)
+	}
+	if third == "" {
+		t.Error("GetJWT() returned empty token")
+	}
+
+	auth.jwtMutex.RLock()
+	expiresAt := auth.jwtExpiresAt
+	auth.jwtMutex.RUnlock()
+	if time.Until(expiresAt) < jwtLifetime-time.Minute {
+		t.Errorf("Expected refreshed token to expire in about %v, got %v", jwtLifetime, time.Until(expiresAt))
+	}
+
+	auth.InvalidateJWT()
+	auth.jwtMutex.RLock()
+	cached := auth.cachedJWT
+	auth.jwtMutex.RUnlock()
+	if cached != "" {
+		t.Error("InvalidateJWT() should clear the cached token")
+	}
+}
+
+func TestGitHubAppAuth_GetJWT_Concurrent(t *testing.T) {
+	config := &types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+	}
+
+	auth, err := NewGitHubAppAuth(config)
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 20)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := auth.GetJWT()
+			if err != nil {
+				t.Errorf("GetJWT() error = %v", err)
+			}
+			tokens[i] = token
+		}(i)
+	}
+	wg.Wait()
+
+	for _, token := range tokens {
+		if token != tokens[0] {
+			t.Fatal("Concurrent GetJWT() calls should share a single cached token")
+		}
+	}
+}
+
+func BenchmarkGitHubAppAuth_GenerateJWT(b *testing.B) {
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+	})
+	if err != nil {
+		b.Fatalf("Failed to create auth: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := auth.GenerateJWT(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGitHubAppAuth_GetJWT(b *testing.B) {
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+	})
+	if err != nil {
+		b.Fatalf("Failed to create auth: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := auth.GetJWT(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func TestTokenManager_IsTokenExpired(t *testing.T) {
 	config := &types.GitHubAppConfig{
 		AppID:          "12345",
@@ -331,12 +494,12 @@ func TestTokenManager_GetCacheStats(t *testing.T) {
 	tm := NewTokenManager(auth, 5*time.Minute)
 	stats := tm.GetCacheStats()
 
-	if stats["total_cached"] != 0 {
-		t.Errorf("Expected 0 cached tokens, got %v", stats["total_cached"])
+	if stats.TotalCached != 0 {
+		t.Errorf("Expected 0 cached tokens, got %v", stats.TotalCached)
 	}
 
-	if stats["renew_buffer"] != "5m0s" {
-		t.Errorf("Expected renew buffer of 5m0s, got %v", stats["renew_buffer"])
+	if stats.RenewBuffer != 5*time.Minute {
+		t.Errorf("Expected renew buffer of 5m0s, got %v", stats.RenewBuffer)
 	}
 }
 
@@ -379,7 +542,542 @@ func TestTokenManager_ClearCache(t *testing.T) {
 	// Clear empty cache
 	tm.ClearCache()
 	stats := tm.GetCacheStats()
-	if stats["total_cached"] != 0 {
-		t.Errorf("Expected 0 cached tokens after clear, got %v", stats["total_cached"])
+	if stats.TotalCached != 0 {
+		t.Errorf("Expected 0 cached tokens after clear, got %v", stats.TotalCached)
+	}
+}
+
+func TestGitHubAppAuth_ListInstallations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := installationsPerPage
+		if r.URL.Query().Get("page") == "2" {
+			count = 1
+		}
+
+		installations := make([]types.GitHubAppInstallation, count)
+		for i := range installations {
+			installations[i].ID = i + 1
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(installations)
+	}))
+	defer server.Close()
+
+	auth, err := NewAppAuth(&types.GitHubAppConfig{
+		AppID:      "12345",
+		PrivateKey: testPrivateKey,
+		BaseURL:    server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	installations, err := auth.ListInstallations(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstallations() error = %v", err)
+	}
+	if len(installations) != installationsPerPage+1 {
+		t.Errorf("Expected %d installations, got %d", installationsPerPage+1, len(installations))
+	}
+
+	if _, err := auth.GetInstallationToken(); err == nil {
+		t.Error("GetInstallationToken() should fail without an installation_id")
+	}
+}
+
+func TestGitHubAppAuth_GetRepositoryInstallation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/repos/my%20org/my-repo/installation" {
+			t.Errorf("Unexpected path %s", r.URL.EscapedPath())
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(types.GitHubAppInstallation{ID: 42})
+	}))
+	defer server.Close()
+
+	auth, err := NewAppAuth(&types.GitHubAppConfig{
+		AppID:      "12345",
+		PrivateKey: testPrivateKey,
+		BaseURL:    server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	installation, err := auth.GetRepositoryInstallation(context.Background(), "my org", "my-repo")
+	if err != nil {
+		t.Fatalf("GetRepositoryInstallation() error = %v", err)
+	}
+	if installation.ID != 42 {
+		t.Errorf("Expected installation 42, got %d", installation.ID)
+	}
+
+	for _, repo := range [][2]string{{"", "repo"}, {"owner", ""}, {"a", "../../app"}, {"..", "app"}, {"owner", "."}} {
+		if _, err := auth.GetRepositoryInstallation(context.Background(), repo[0], repo[1]); err == nil {
+			t.Errorf("GetRepositoryInstallation(%q, %q) should fail", repo[0], repo[1])
+		}
+	}
+}
+
+func TestGitHubAppAuth_CreateInstallationToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/installations/42/access_tokens" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+
+		var request types.InstallationTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		if len(request.Repositories) != 1 || request.Repositories[0] != "hello" {
+			t.Errorf("Expected repositories [hello], got %v", request.Repositories)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{
+			Token:       "ghs_scoped",
+			ExpiresAt:   time.Now().Add(time.Hour),
+			Permissions: request.Permissions,
+		})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	token, err := auth.CreateInstallationToken(context.Background(), "42", &types.InstallationTokenRequest{
+		Repositories: []string{"hello"},
+		Permissions:  map[string]string{"contents": "read"},
+	})
+	if err != nil {
+		t.Fatalf("CreateInstallationToken() error = %v", err)
+	}
+	if token.Token != "ghs_scoped" || token.Permissions["contents"] != "read" {
+		t.Errorf("Unexpected token %+v", token)
+	}
+}
+
+func TestTokenManager_GetScopedToken(t *testing.T) {
+	var mints int
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		mints++
+		n := mints
+		mutex.Unlock()
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{
+			Token:     "ghs_" + strconv.Itoa(n),
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	tm := NewTokenManager(auth, 5*time.Minute)
+
+	unscoped, err := tm.GetTokenForInstallation("42")
+	if err != nil {
+		t.Fatalf("GetTokenForInstallation() error = %v", err)
+	}
+
+	scoped, err := tm.GetScopedToken(context.Background(), "42", &types.InstallationTokenRequest{
+		Repositories: []string{"a", "b"},
+		Permissions:  map[string]string{"contents": "read", "issues": "write"},
+	})
+	if err != nil {
+		t.Fatalf("GetScopedToken() error = %v", err)
+	}
+	if scoped.Token == unscoped.Token {
+		t.Error("Scoped and unscoped tokens should be cached separately")
 	}
-} 
\ No newline at end of file
+
+	// The same scope in a different order is served from the cache
+	again, err := tm.GetScopedToken(context.Background(), "42", &types.InstallationTokenRequest{
+		Repositories: []string{"b", "a"},
+		Permissions:  map[string]string{"issues": "write", "contents": "read"},
+	})
+	if err != nil || again.Token != scoped.Token {
+		t.Errorf("Expected cached token %s, got %v (%v)", scoped.Token, again, err)
+	}
+
+	if mints != 2 {
+		t.Errorf("Expected 2 tokens to be minted, got %d", mints)
+	}
+
+	tm.InvalidateInstallationToken("42")
+	if stats := tm.GetCacheStats(); stats.TotalCached != 0 {
+		t.Errorf("Expected scoped tokens to be invalidated, got %v cached", stats.TotalCached)
+	}
+}
+
+func TestGitHubAppAuth_TokenPolicy(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{Token: "ghs_test", ExpiresAt: time.Now().Add(time.Hour)})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	denied := errors.New("denied")
+	allow := true
+	auth.SetTokenPolicy(tokenPolicyFunc(func(ctx context.Context, installationID string, request *types.InstallationTokenRequest) error {
+		if !allow {
+			return denied
+		}
+		return nil
+	}))
+
+	tm := NewTokenManager(auth, 5*time.Minute)
+	if _, err := tm.GetToken(); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	allow = false
+	if _, err := auth.GetInstallationToken(); !errors.Is(err, denied) {
+		t.Errorf("Expected policy denial, got %v", err)
+	}
+	if _, err := tm.GetToken(); !errors.Is(err, denied) {
+		t.Errorf("Expected policy denial for a cached token, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected denied requests not to reach GitHub, got %d requests", requests)
+	}
+}
+
+func TestGitHubAppAuth_TokenPolicyAuditEvents(t *testing.T) {
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	var events []audit.Event
+	auth.SetAuditSink(audit.SinkFunc(func(event audit.Event) {
+		events = append(events, event)
+	}))
+
+	tokenPolicy, err := policy.Parse([]byte("rules:\n  - name: ci\n    callers: [ci]\n    installations: [\"67890\"]\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	auth.SetTokenPolicy(tokenPolicy)
+
+	ctx := policy.WithCaller(context.Background(), "ci")
+	if err := auth.AuthorizeToken(ctx, "67890", nil); err != nil {
+		t.Errorf("AuthorizeToken() error = %v", err)
+	}
+	if err := auth.AuthorizeToken(ctx, "1", nil); !errors.Is(err, policy.ErrDenied) {
+		t.Errorf("Expected ErrDenied, got %v", err)
+	}
+
+	// Policies that cannot explain their decisions are recorded too
+	auth.SetTokenPolicy(tokenPolicyFunc(func(ctx context.Context, installationID string, request *types.InstallationTokenRequest) error {
+		return errors.New("closed for maintenance")
+	}))
+	auth.AuthorizeToken(ctx, "67890", nil)
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 decision events, got %+v", events)
+	}
+	if events[0].Type != audit.EventAllow || events[0].Rule != "ci" || events[0].Caller != "ci" || events[0].AppID != "12345" {
+		t.Errorf("Unexpected allow event %+v", events[0])
+	}
+	if events[1].Type != audit.EventDeny || events[1].InstallationID != "1" || events[1].Reason == "" {
+		t.Errorf("Unexpected deny event %+v", events[1])
+	}
+	if events[2].Type != audit.EventDeny || events[2].Reason != "closed for maintenance" {
+		t.Errorf("Unexpected deny event %+v", events[2])
+	}
+}
+
+type tokenPolicyFunc func(ctx context.Context, installationID string, request *types.InstallationTokenRequest) error
+
+func (f tokenPolicyFunc) Authorize(ctx context.Context, installationID string, request *types.InstallationTokenRequest) error {
+	return f(ctx, installationID, request)
+}
+
+func TestTokenManager_AuditEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{
+			Token:       "ghs_secret",
+			ExpiresAt:   time.Now().Add(time.Hour),
+			Permissions: map[string]string{"contents": "read"},
+		})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	var events []audit.Event
+	auth.SetAuditSink(audit.SinkFunc(func(event audit.Event) {
+		events = append(events, event)
+	}))
+
+	tm := NewTokenManager(auth, 5*time.Minute)
+	ctx := audit.WithLabels(context.Background(), map[string]string{"job": "deploy"})
+	request := &types.InstallationTokenRequest{Repositories: []string{"app"}}
+
+	token, err := tm.GetScopedToken(ctx, "67890", request)
+	if err != nil {
+		t.Fatalf("GetScopedToken() error = %v", err)
+	}
+	if _, err := tm.GetScopedToken(ctx, "67890", request); err != nil {
+		t.Fatalf("GetScopedToken() error = %v", err)
+	}
+	tm.InvalidateInstallationToken("67890")
+	if err := auth.RevokeInstallationToken(context.Background(), token.Token); err != nil {
+		t.Fatalf("RevokeInstallationToken() error = %v", err)
+	}
+
+	want := []audit.EventType{audit.EventMint, audit.EventCacheHit, audit.EventInvalidate, audit.EventRevoke}
+	if len(events) != len(want) {
+		t.Fatalf("Expected events %v, got %+v", want, events)
+	}
+	for i, event := range events {
+		if event.Type != want[i] {
+			t.Errorf("Event %d: expected %s, got %s", i, want[i], event.Type)
+		}
+		if event.Fingerprint != audit.Fingerprint("ghs_secret") {
+			t.Errorf("Event %d: expected token fingerprint, got %q", i, event.Fingerprint)
+		}
+		if event.AppID != "12345" {
+			t.Errorf("Event %d: expected app ID 12345, got %q", i, event.AppID)
+		}
+	}
+
+	mint := events[0]
+	if mint.InstallationID != "67890" || mint.ExpiresAt == nil || mint.Permissions["contents"] != "read" || len(mint.Repositories) != 1 {
+		t.Errorf("Unexpected mint event %+v", mint)
+	}
+	if mint.Labels["job"] != "deploy" {
+		t.Errorf("Expected caller labels on the mint event, got %v", mint.Labels)
+	}
+}
+
+func TestTokenManager_Metrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		w.Header().Set("X-RateLimit-Resource", "core")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{
+			Token:     "ghs_secret",
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	registry := metrics.NewRegistry()
+	auth.SetMetrics(registry)
+
+	tm := NewTokenManager(auth, 5*time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := tm.GetToken(); err != nil {
+			t.Fatalf("GetToken() error = %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := registry.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		`ghappauth_token_mints_total{result="success"} 1`,
+		"ghappauth_token_cache_misses_total 1",
+		"ghappauth_token_cache_hits_total 1",
+		`ghappauth_http_requests_total{method="POST",endpoint="/app/installations/{installation_id}/access_tokens",status="201"} 1`,
+		`ghappauth_rate_limit_remaining{resource="core"} 4999`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected metrics to contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestTokenManager_Tracing(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("X-GitHub-Request-Id", "ABCD:1234")
+		if attempts == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{
+			Token:     "ghs_secret",
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+	auth.httpClient.config.RetryDelay = time.Millisecond
+
+	recorder := tracetest.NewSpanRecorder()
+	auth.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	tm := NewTokenManager(auth, 5*time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := tm.GetToken(); err != nil {
+			t.Fatalf("GetToken() error = %v", err)
+		}
+	}
+
+	spans := recorder.Ended()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	want := []string{
+		"GitHubAppAuth.GenerateJWT",
+		"POST /app/installations/{installation_id}/access_tokens",
+		"POST /app/installations/{installation_id}/access_tokens",
+		"TokenManager.GetToken",
+		"TokenManager.GetToken",
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("Expected spans %v, got %v", want, names)
+	}
+
+	attrs := func(span sdktrace.ReadOnlySpan) map[string]string {
+		values := make(map[string]string)
+		for _, attr := range span.Attributes() {
+			values[string(attr.Key)] = attr.Value.Emit()
+		}
+		return values
+	}
+
+	getToken := spans[3]
+	for _, span := range spans[:3] {
+		if span.Parent().SpanID() != getToken.SpanContext().SpanID() {
+			t.Errorf("Span %s is not a child of TokenManager.GetToken", span.Name())
+		}
+	}
+
+	if got := attrs(spans[1])["http.response.status_code"]; got != "502" {
+		t.Errorf("Expected first attempt status 502, got %q", got)
+	}
+	retry := attrs(spans[2])
+	if retry["http.request.resend_count"] != "1" || retry["github.request_id"] != "ABCD:1234" {
+		t.Errorf("Unexpected retry attributes %v", retry)
+	}
+	if got := attrs(spans[3])["ghappauth.token.cache"]; got != "miss" {
+		t.Errorf("Expected cache miss, got %q", got)
+	}
+	if got := attrs(spans[4])["ghappauth.token.cache"]; got != "hit" {
+		t.Errorf("Expected cache hit, got %q", got)
+	}
+}
+
+func TestGitHubAppAuth_Installation_JoinsTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(types.GitHubAppInstallation{ID: 67890})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	auth.SetTracerProvider(provider)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "caller")
+	installation, err := auth.Installation(ctx)
+	parent.End()
+	if err != nil {
+		t.Fatalf("Installation() error = %v", err)
+	}
+	if installation.ID != 67890 {
+		t.Errorf("Expected installation 67890, got %d", installation.ID)
+	}
+
+	found := false
+	for _, span := range recorder.Ended() {
+		if span.Name() != "GET /app/installations/{installation_id}" {
+			continue
+		}
+		found = true
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Span %s is not a child of the caller's span", span.Name())
+		}
+	}
+	if !found {
+		t.Error("Expected a span for the installation request")
+	}
+}
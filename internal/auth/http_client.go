@@ -1,21 +1,34 @@
 package auth
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/avast/retry-go/v4"
+	"go.opentelemetry.io/otel/trace"
+	"ghappauth/internal/metrics"
 	"ghappauth/internal/types"
 )
 
 // HTTPClient wraps the standard http.Client with retry logic and common functionality
 type HTTPClient struct {
-	client *http.Client
-	config *HTTPClientConfig
+	client      *http.Client
+	config      *HTTPClientConfig
+	retryPolicy RetryPolicy
+	breaker     *circuitBreaker
+	responses   *responseCache
+	logger      *slog.Logger
+	metrics     metrics.Recorder
+	tracer      trace.Tracer
 }
 
 // HTTPClientConfig holds configuration for the HTTP client
@@ -25,6 +38,29 @@ type HTTPClientConfig struct {
 	RetryDelay      time.Duration
 	BackoffMultiplier float64
 	UserAgent       string
+	// MaxRetryDelay caps the wait between attempts; 0 means no cap
+	MaxRetryDelay time.Duration
+	// MaxElapsedTime stops retrying once this long has passed since the
+	// first attempt; 0 means no limit
+	MaxElapsedTime time.Duration
+	// RetryPolicy decides which attempts are retried and when. Nil uses a
+	// BackoffPolicy built from the settings above.
+	RetryPolicy RetryPolicy
+	// CircuitBreaker fails requests fast while an endpoint keeps failing.
+	// Nil disables it.
+	CircuitBreaker *CircuitBreakerConfig
+	// ProxyURL routes requests through an HTTP or SOCKS5 proxy. Nil uses the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	ProxyURL *url.URL
+	// RootCAs verifies the certificate of the server, such as a GitHub
+	// Enterprise Server signed by an internal CA. Nil uses the system pool.
+	RootCAs *x509.CertPool
+	// Transport sends the requests, replacing the transport built from
+	// ProxyURL and RootCAs
+	Transport http.RoundTripper
+	// ResponseCache revalidates GET responses with their ETag or
+	// Last-Modified header instead of downloading them again. Nil disables it.
+	ResponseCache *ResponseCacheConfig
 }
 
 // DefaultHTTPClientConfig returns default configuration for the HTTP client
@@ -35,6 +71,8 @@ func DefaultHTTPClientConfig() *HTTPClientConfig {
 		RetryDelay:      1 * time.Second,
 		BackoffMultiplier: 2.0,
 		UserAgent:       "ghappauth/1.0",
+		MaxRetryDelay:   10 * time.Second,
+		MaxElapsedTime:  time.Minute,
 	}
 }
 
@@ -44,12 +82,42 @@ func NewHTTPClient(config *HTTPClientConfig) *HTTPClient {
 		config = DefaultHTTPClientConfig()
 	}
 
+	retryPolicy := config.RetryPolicy
+	if retryPolicy == nil {
+		retryPolicy = NewBackoffPolicy(config)
+	}
+
 	return &HTTPClient{
 		client: &http.Client{
-			Timeout: config.Timeout,
+			Timeout:   config.Timeout,
+			Transport: newTransport(config),
 		},
-		config: config,
+		config:      config,
+		retryPolicy: retryPolicy,
+		breaker:     newCircuitBreaker(config.CircuitBreaker),
+		responses:   newResponseCache(config.ResponseCache),
+		logger:      discardLogger,
+		metrics:     metrics.Discard,
+		tracer:      noopTracer,
+	}
+}
+
+// SetLogger sets the logger for requests, retries and rate limit state.
+// Credentials are redacted from everything logged. A nil logger disables logging.
+func (c *HTTPClient) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		c.logger = discardLogger
+		return
 	}
+	c.logger = slog.New(NewRedactingHandler(logger.Handler()))
+}
+
+// SetUserAgent sets the User-Agent header sent with every request
+func (c *HTTPClient) SetUserAgent(userAgent string) {
+	// Copy the configuration, which may be shared with other clients
+	config := *c.config
+	config.UserAgent = userAgent
+	c.config = &config
 }
 
 // RequestConfig holds configuration for individual requests
@@ -58,29 +126,115 @@ type RequestConfig struct {
 	URL         string
 	AuthToken   string
 	Accept      string
+	// Body is read once and resent on every attempt
 	Body        io.Reader
 	ExpectedStatus int
+	// Endpoint is the route template the URL was built from, such as
+	// /app/installations/{installation_id}, used to label metrics. The URL
+	// path is used when empty.
+	Endpoint string
+	// RetrySafe allows a request whose method is not idempotent, such as
+	// POST, to be retried
+	RetrySafe bool
+	// GetBody returns a new copy of the body for each attempt. It takes
+	// precedence over JSONBody and Body.
+	GetBody func() (io.ReadCloser, error)
+	// JSONBody is encoded as the JSON body of the request, which is sent as
+	// application/json. It takes precedence over Body.
+	JSONBody interface{}
+	// CacheCredential identifies the credential of the request in the
+	// response cache when AuthToken changes over time, as JWTs do. AuthToken
+	// is used when empty.
+	CacheCredential string
 }
 
 // RetryableError represents an error that should trigger a retry
 type RetryableError struct {
 	StatusCode int
+	// Err is the transport error when no response was received
+	Err error
 }
 
 func (e *RetryableError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
 	return fmt.Sprintf("retryable status code: %d", e.StatusCode)
 }
 
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// APIError represents an unexpected response status from the GitHub API
+type APIError struct {
+	StatusCode int
+	types.GitHubAPIError
+	// Err is set when the error response body could not be decoded
+	Err error
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("GitHub API error: status %d (failed to decode error response: %v)", e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("GitHub API error: %s (status: %d)", e.Message, e.StatusCode)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
 // doRequest performs an HTTP request with retry logic and common error handling
 func (c *HTTPClient) doRequest(ctx context.Context, config *RequestConfig) (*http.Response, error) {
 	var resp *http.Response
-	
-	err := retry.Do(
-		func() error {
-			req, err := http.NewRequestWithContext(ctx, config.Method, config.URL, config.Body)
+	attempt := 0
+	endpoint := endpointLabel(config)
+	circuitKey := breakerKey(config, endpoint)
+	firstAttempt := time.Now()
+	var nextDelay time.Duration
+	var lastErr error // Error of the last attempt
+
+	attempts := c.config.MaxRetries
+	if !retryAllowed(config) {
+		attempts = 1
+	}
+
+	getBody, err := newBodyFunc(config)
+	if err != nil {
+		return nil, err
+	}
+
+	responseKey, cached := c.responses.lookup(config)
+
+	err = retry.Do(
+		func() (err error) {
+			defer func() { lastErr = err }()
+			if err := c.breaker.allow(circuitKey); err != nil {
+				return err
+			}
+
+			attempt++
+			ctx, span := c.startAttemptSpan(ctx, config, endpoint, attempt)
+			defer func() { endSpan(span, err) }()
+
+			body, err := getBody()
+			if err != nil {
+				c.breaker.abandon(circuitKey)
+				return fmt.Errorf("failed to create request body: %w", err)
+			}
+
+			req, err := http.NewRequestWithContext(ctx, config.Method, config.URL, body)
 			if err != nil {
+				c.breaker.abandon(circuitKey)
 				return fmt.Errorf("failed to create request: %w", err)
 			}
+			if config.GetBody != nil {
+				req.GetBody = config.GetBody
+			}
+			if config.GetBody == nil && config.JSONBody != nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
 
 			if config.AuthToken != "" {
 				req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", config.AuthToken))
@@ -91,13 +245,35 @@ func (c *HTTPClient) doRequest(ctx context.Context, config *RequestConfig) (*htt
 				req.Header.Set("Accept", "application/vnd.github.v3+json")
 			}
 			req.Header.Set("User-Agent", c.config.UserAgent)
+			cached.setConditionalHeaders(req)
 
+			start := time.Now()
 			response, err := c.client.Do(req)
 			if err != nil {
-				return fmt.Errorf("failed to make request: %w", err)
+				transportErr := &TransportError{Err: err}
+				if ctx.Err() == nil {
+					transportErr.Kind = transportErrorKind(err)
+				}
+				c.metrics.HTTPRequest(config.Method, endpoint, 0, time.Since(start))
+				c.logger.DebugContext(ctx, "GitHub API request failed",
+					"method", config.Method, "url", config.URL, "attempt", attempt, "kind", transportErr.Kind, "error", err)
+
+				if ctx.Err() != nil {
+					c.breaker.abandon(circuitKey)
+					return transportErr
+				}
+
+				c.recordOutcome(ctx, circuitKey, true)
+				if c.retryPolicy.Retryable(nil, transportErr) {
+					return &RetryableError{Err: transportErr}
+				}
+				return transportErr
 			}
+			c.observeResponse(ctx, config, endpoint, response, attempt, time.Since(start))
 
-			if shouldRetry(response.StatusCode) {
+			retryable := c.retryPolicy.Retryable(response, nil)
+			c.recordOutcome(ctx, circuitKey, retryable)
+			if retryable {
 				response.Body.Close()
 				return &RetryableError{StatusCode: response.StatusCode}
 			}
@@ -105,24 +281,126 @@ func (c *HTTPClient) doRequest(ctx context.Context, config *RequestConfig) (*htt
 			resp = response
 			return nil
 		},
-		retry.Attempts(c.config.MaxRetries),
-		retry.Delay(c.config.RetryDelay),
-		retry.DelayType(retry.BackOffDelay),
+		retry.Context(ctx),
+		retry.Attempts(attempts),
+		retry.DelayType(func(n uint, err error, retryConfig *retry.Config) time.Duration {
+			attrs := []any{"method", config.Method, "url", config.URL, "attempt", attempt, "delay", nextDelay}
+			if retryable, ok := err.(*RetryableError); ok && retryable.StatusCode != 0 {
+				attrs = append(attrs, "status", retryable.StatusCode)
+			} else {
+				attrs = append(attrs, "error", err)
+			}
+			c.logger.WarnContext(ctx, "retrying GitHub API request", attrs...)
+			c.metrics.HTTPRetry(config.Method, endpoint)
+			return nextDelay
+		}),
 		retry.LastErrorOnly(true),
 		retry.RetryIf(func(err error) bool {
-			_, ok := err.(*RetryableError)
-			return ok
+			if _, ok := err.(*RetryableError); !ok {
+				return false
+			}
+
+			// Give up when waiting would run past the maximum elapsed time
+			nextDelay = c.retryPolicy.Delay(uint(attempt))
+			maxElapsed := c.retryPolicy.MaxElapsed()
+			return maxElapsed <= 0 || time.Since(firstAttempt)+nextDelay <= maxElapsed
 		}),
 	)
 
+	var circuitErr *CircuitOpenError
+	if errors.As(err, &circuitErr) {
+		return nil, err
+	}
+	// When ctx is done during a backoff wait only the context error is
+	// returned; keep the failure that caused the retry reachable as well
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil && lastErr != nil && !errors.Is(lastErr, ctxErr) {
+		err = fmt.Errorf("%w; last attempt: %w", err, lastErr)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("request failed after %d attempts: %w", c.config.MaxRetries, err)
+		return nil, fmt.Errorf("request failed after %d attempts: %w", attempt, err)
+	}
+
+	resp, notModified, err := c.responses.resolve(responseKey, cached, resp)
+	if notModified {
+		c.logger.DebugContext(ctx, "GitHub API response not modified, using cached response",
+			"method", config.Method, "url", config.URL)
 	}
+	return resp, err
+}
 
-	return resp, nil
+// recordOutcome counts an attempt for the circuit breaker, logging when the
+// breaker opens or closes
+func (c *HTTPClient) recordOutcome(ctx context.Context, key string, failed bool) {
+	before, after := c.breaker.record(key, failed)
+	switch {
+	case after == circuitOpen && before != circuitOpen:
+		c.logger.WarnContext(ctx, "circuit breaker opened, failing GitHub API requests fast",
+			"endpoint", key, "previous_state", before.String(), "open_duration", c.breaker.openDuration)
+	case after == circuitClosed && before != circuitClosed:
+		c.logger.InfoContext(ctx, "circuit breaker closed", "endpoint", key)
+	}
 }
 
-// DoRequest performs an HTTP request and decodes the JSON response
+// newBodyFunc returns a function that creates the body of each attempt of a
+// request, so that retries send the whole body again
+func newBodyFunc(config *RequestConfig) (func() (io.Reader, error), error) {
+	var data []byte
+	switch {
+	case config.GetBody != nil:
+		return func() (io.Reader, error) {
+			return config.GetBody()
+		}, nil
+	case config.JSONBody != nil:
+		encoded, err := json.Marshal(config.JSONBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request body: %w", err)
+		}
+		data = encoded
+	case config.Body != nil:
+		buffered, err := io.ReadAll(config.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		data = buffered
+	default:
+		return func() (io.Reader, error) {
+			return nil, nil
+		}, nil
+	}
+
+	return func() (io.Reader, error) {
+		return bytes.NewReader(data), nil
+	}, nil
+}
+
+// observeResponse logs and measures a completed request, warning when the
+// rate limit runs low
+func (c *HTTPClient) observeResponse(ctx context.Context, config *RequestConfig, endpoint string, response *http.Response, attempt int, duration time.Duration) {
+	c.metrics.HTTPRequest(config.Method, endpoint, response.StatusCode, duration)
+	traceResponse(ctx, response)
+
+	attrs := []any{
+		"method", config.Method,
+		"url", config.URL,
+		"status", response.StatusCode,
+		"attempt", attempt,
+		"duration", duration,
+	}
+
+	limit, ok := parseRateLimit(response.Header)
+	if ok {
+		attrs = append(attrs, limit.logAttrs()...)
+		c.metrics.RateLimit(limit.resource, limit.limit, limit.remaining)
+	}
+
+	if ok && limit.low() {
+		c.logger.WarnContext(ctx, "GitHub API rate limit is running low", attrs...)
+		return
+	}
+	c.logger.DebugContext(ctx, "GitHub API request", attrs...)
+}
+
+// DoRequest performs an HTTP request and decodes the JSON response into result, unless result is nil
 func (c *HTTPClient) DoRequest(ctx context.Context, config *RequestConfig, result interface{}) error {
 	resp, err := c.doRequest(ctx, config)
 	if err != nil {
@@ -131,11 +409,15 @@ func (c *HTTPClient) DoRequest(ctx context.Context, config *RequestConfig, resul
 	defer resp.Body.Close()
 
 	if config.ExpectedStatus != 0 && resp.StatusCode != config.ExpectedStatus {
-		var apiError types.GitHubAPIError
-		if err := json.NewDecoder(resp.Body).Decode(&apiError); err != nil {
-			return fmt.Errorf("GitHub API error: status %d (failed to decode error response: %w)", resp.StatusCode, err)
+		apiError := &APIError{StatusCode: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(&apiError.GitHubAPIError); err != nil {
+			apiError.Err = err
 		}
-		return fmt.Errorf("GitHub API error: %s (status: %d)", apiError.Message, resp.StatusCode)
+		return apiError
+	}
+
+	if result == nil {
+		return nil
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
@@ -2,8 +2,10 @@ package auth
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -252,4 +254,76 @@ func TestShouldRetry(t *testing.T) {
 	}
 }
 
- 
\ No newline at end of file
+ 
+func TestHTTPClient_doRequest_ReplaysBody(t *testing.T) {
+	var bodies []string
+	var contentTypes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		if len(bodies)%2 == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(&HTTPClientConfig{MaxRetries: 2, RetryDelay: time.Millisecond})
+
+	getBodyCalls := 0
+	tests := []struct {
+		name            string
+		config          RequestConfig
+		wantBody        string
+		wantContentType string
+	}{
+		{
+			name:     "reader",
+			config:   RequestConfig{Body: strings.NewReader(`{"repositories":["app"]}`)},
+			wantBody: `{"repositories":["app"]}`,
+		},
+		{
+			name: "factory",
+			config: RequestConfig{GetBody: func() (io.ReadCloser, error) {
+				getBodyCalls++
+				return io.NopCloser(strings.NewReader("payload")), nil
+			}},
+			wantBody: "payload",
+		},
+		{
+			name:            "JSON",
+			config:          RequestConfig{JSONBody: map[string][]string{"repositories": {"app"}}},
+			wantBody:        `{"repositories":["app"]}`,
+			wantContentType: "application/json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodies, contentTypes = nil, nil
+			config := tt.config
+			config.Method = "POST"
+			config.URL = server.URL
+			config.RetrySafe = true
+
+			resp, err := client.doRequest(context.Background(), &config)
+			if err != nil {
+				t.Fatalf("doRequest() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if len(bodies) != 2 || bodies[0] != tt.wantBody || bodies[1] != tt.wantBody {
+				t.Errorf("Expected %q on both attempts, got %q", tt.wantBody, bodies)
+			}
+			if contentTypes[1] != tt.wantContentType {
+				t.Errorf("Expected content type %q, got %q", tt.wantContentType, contentTypes[1])
+			}
+		})
+	}
+
+	if getBodyCalls != 2 {
+		t.Errorf("Expected GetBody to be called once per attempt, got %d calls", getBodyCalls)
+	}
+}
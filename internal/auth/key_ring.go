@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"sync"
+)
+
+// keyRing holds the App private keys in order of preference. The active key
+// signs new JWTs; when GitHub rejects it the ring advances to the next key.
+type keyRing struct {
+	mutex   sync.RWMutex
+	keys    []*rsa.PrivateKey
+	active  int
+	version uint64 // Incremented whenever the active signing key changes
+}
+
+// newKeyRing creates a key ring with the primary key first
+func newKeyRing(keys []*rsa.PrivateKey) *keyRing {
+	return &keyRing{keys: keys}
+}
+
+// current returns the active signing key and the version it belongs to
+func (r *keyRing) current() (*rsa.PrivateKey, uint64) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.keys[r.active], r.version
+}
+
+// currentVersion returns the version of the active signing key
+func (r *keyRing) currentVersion() uint64 {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.version
+}
+
+// activeIndex returns the position of the active key, 0 being the primary
+func (r *keyRing) activeIndex() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.active
+}
+
+// advance moves past the key at the given version after GitHub rejected it.
+// It returns false when there is no other key left to try.
+func (r *keyRing) advance(version uint64) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Another caller already moved on from the rejected key
+	if r.version != version {
+		return true
+	}
+
+	if r.active+1 >= len(r.keys) {
+		return false
+	}
+
+	r.active++
+	r.version++
+	return true
+}
+
+// replace swaps in a new set of keys and makes the first one active
+func (r *keyRing) replace(keys []*rsa.PrivateKey) {
+	r.mutex.Lock()
+	r.keys = keys
+	r.active = 0
+	r.version++
+	r.mutex.Unlock()
+}
+
+// replacePrimary swaps the primary key, keeping the fallbacks, and makes it active
+func (r *keyRing) replacePrimary(key *rsa.PrivateKey) {
+	r.mutex.Lock()
+	keys := make([]*rsa.PrivateKey, len(r.keys))
+	copy(keys, r.keys)
+	keys[0] = key
+	r.keys = keys
+	r.active = 0
+	r.version++
+	r.mutex.Unlock()
+}
+
+// reset makes the primary key active again
+func (r *keyRing) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.active != 0 {
+		r.active = 0
+		r.version++
+	}
+}
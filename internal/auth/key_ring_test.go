@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"ghappauth/internal/types"
+)
+
+// generateTestKey returns a fresh RSA key and its PKCS#1 PEM encoding
+func generateTestKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	return key, string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}))
+}
+
+// newKeyCheckingServer starts a server that only accepts JWTs signed by one of the given keys
+func newKeyCheckingServer(t *testing.T, attempts *int32, accepted ...*rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(attempts, 1)
+
+		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for _, key := range accepted {
+			_, err := jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			})
+			if err == nil {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`{"id": 1, "name": "test-app"}`))
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Bad credentials"}`))
+	}))
+}
+
+func TestKeyRing_Advance(t *testing.T) {
+	first, _ := generateTestKey(t)
+	second, _ := generateTestKey(t)
+	ring := newKeyRing([]*rsa.PrivateKey{first, second})
+
+	key, version := ring.current()
+	if !key.Equal(first) {
+		t.Fatal("Expected primary key to be active")
+	}
+
+	if !ring.advance(version) {
+		t.Fatal("advance() should move to the fallback key")
+	}
+	if ring.activeIndex() != 1 {
+		t.Errorf("Expected active index 1, got %d", ring.activeIndex())
+	}
+
+	// A stale version means another caller already advanced
+	if !ring.advance(version) {
+		t.Error("advance() with a stale version should report another key is available")
+	}
+	if ring.activeIndex() != 1 {
+		t.Errorf("advance() with a stale version should not move, got index %d", ring.activeIndex())
+	}
+
+	_, version = ring.current()
+	if ring.advance(version) {
+		t.Error("advance() should fail once the last key is rejected")
+	}
+
+	ring.replacePrimary(second)
+	key, _ = ring.current()
+	if !key.Equal(second) || ring.activeIndex() != 0 {
+		t.Error("replacePrimary() should make the new primary key active")
+	}
+}
+
+func TestGitHubAppAuth_FallbackKey(t *testing.T) {
+	oldKey, oldPEM := generateTestKey(t)
+	_, newPEM := generateTestKey(t)
+
+	var attempts int32
+	server := newKeyCheckingServer(t, &attempts, oldKey)
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:               "12345",
+		PrivateKey:          newPEM,
+		FallbackPrivateKeys: []string{oldPEM},
+		InstallationID:      "67890",
+		BaseURL:             server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	app, err := auth.GetAppInfo()
+	if err != nil {
+		t.Fatalf("GetAppInfo() error = %v", err)
+	}
+	if app.Name != "test-app" {
+		t.Errorf("Expected app name 'test-app', got %s", app.Name)
+	}
+	if auth.ActiveKeyIndex() != 1 {
+		t.Errorf("Expected fallback key to be active, got index %d", auth.ActiveKeyIndex())
+	}
+	if atomic.LoadInt32(&attempts) != 2 {
+		t.Errorf("Expected 2 attempts, got %d", atomic.LoadInt32(&attempts))
+	}
+
+	// The fallback key stays active for later calls
+	if _, err := auth.GetAppInfo(); err != nil {
+		t.Fatalf("GetAppInfo() error = %v", err)
+	}
+	if atomic.LoadInt32(&attempts) != 3 {
+		t.Errorf("Expected 3 attempts, got %d", atomic.LoadInt32(&attempts))
+	}
+}
+
+func TestGitHubAppAuth_AllKeysRejected(t *testing.T) {
+	_, firstPEM := generateTestKey(t)
+	_, secondPEM := generateTestKey(t)
+
+	var attempts int32
+	server := newKeyCheckingServer(t, &attempts)
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:               "12345",
+		PrivateKey:          firstPEM,
+		FallbackPrivateKeys: []string{secondPEM},
+		InstallationID:      "67890",
+		BaseURL:             server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	_, err = auth.GetAppInfo()
+	var apiError *APIError
+	if !errors.As(err, &apiError) || apiError.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 APIError, got %v", err)
+	}
+	if atomic.LoadInt32(&attempts) != 2 {
+		t.Errorf("Expected one attempt per key, got %d", atomic.LoadInt32(&attempts))
+	}
+}
+
+func TestGitHubAppAuth_SetPrivateKeys(t *testing.T) {
+	newKey, newPEM := generateTestKey(t)
+
+	var attempts int32
+	server := newKeyCheckingServer(t, &attempts, newKey)
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	if _, err := auth.GetAppInfo(); err == nil {
+		t.Fatal("GetAppInfo() should fail before the new key is installed")
+	}
+
+	if err := auth.SetPrivateKeys(newPEM, testPrivateKey); err != nil {
+		t.Fatalf("SetPrivateKeys() error = %v", err)
+	}
+	if auth.ActiveKeyIndex() != 0 {
+		t.Errorf("Expected primary key to be active after swap, got index %d", auth.ActiveKeyIndex())
+	}
+
+	if _, err := auth.GetAppInfo(); err != nil {
+		t.Fatalf("GetAppInfo() error = %v", err)
+	}
+
+	if err := auth.SetPrivateKeys("invalid-key"); err == nil {
+		t.Error("SetPrivateKeys() should reject an invalid key")
+	}
+}
+
+func TestGitHubAppAuth_ClockSkewKeepsKey(t *testing.T) {
+	_, firstPEM := generateTestKey(t)
+	_, secondPEM := generateTestKey(t)
+
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "'Expiration time' claim ('exp') is too far in the future"}`))
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:               "12345",
+		PrivateKey:          firstPEM,
+		FallbackPrivateKeys: []string{secondPEM},
+		InstallationID:      "67890",
+		BaseURL:             server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	if _, err := auth.GetAppInfo(); err == nil {
+		t.Fatal("Expected the 401 to be returned")
+	}
+	if auth.ActiveKeyIndex() != 0 || atomic.LoadInt32(&attempts) != 1 {
+		t.Errorf("Expected the primary key to stay active after 1 attempt, got index %d after %d attempts",
+			auth.ActiveKeyIndex(), atomic.LoadInt32(&attempts))
+	}
+}
+
+func TestGitHubAppAuth_ResetActiveKey(t *testing.T) {
+	oldKey, oldPEM := generateTestKey(t)
+	_, newPEM := generateTestKey(t)
+
+	var attempts int32
+	server := newKeyCheckingServer(t, &attempts, oldKey)
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:               "12345",
+		PrivateKey:          newPEM,
+		FallbackPrivateKeys: []string{oldPEM},
+		InstallationID:      "67890",
+		BaseURL:             server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	if _, err := auth.GetAppInfo(); err != nil {
+		t.Fatalf("GetAppInfo() error = %v", err)
+	}
+	if auth.ActiveKeyIndex() != 1 {
+		t.Fatalf("Expected fallback key to be active, got index %d", auth.ActiveKeyIndex())
+	}
+
+	auth.ResetActiveKey()
+	if auth.ActiveKeyIndex() != 0 {
+		t.Errorf("Expected the primary key to be active after a reset, got index %d", auth.ActiveKeyIndex())
+	}
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	fileKeyScheme   = "file://"
+	envKeyScheme    = "env://"
+	base64KeyScheme = "base64://"
+)
+
+// KeyResolver resolves a secret reference such as "vault://secret/data/app#key"
+// into PEM-encoded private key material
+type KeyResolver interface {
+	ResolveKey(ctx context.Context, ref string) (string, error)
+}
+
+// KeyResolverFunc adapts an ordinary function to the KeyResolver interface
+type KeyResolverFunc func(ctx context.Context, ref string) (string, error)
+
+// ResolveKey calls f(ctx, ref)
+func (f KeyResolverFunc) ResolveKey(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+var (
+	keyResolversMutex sync.RWMutex
+	keyResolvers      = make(map[string]KeyResolver)
+)
+
+// RegisterKeyResolver makes a resolver available for private key references
+// starting with scheme + "://". Registering a resolver for an existing scheme
+// replaces it; the built-in file, env and base64 schemes cannot be overridden.
+func RegisterKeyResolver(scheme string, resolver KeyResolver) error {
+	scheme = strings.TrimSuffix(scheme, "://")
+	if scheme == "" {
+		return fmt.Errorf("scheme cannot be empty")
+	}
+	if resolver == nil {
+		return fmt.Errorf("resolver cannot be nil")
+	}
+
+	switch scheme + "://" {
+	case fileKeyScheme, envKeyScheme, base64KeyScheme:
+		return fmt.Errorf("scheme %q is built in and cannot be overridden", scheme)
+	}
+
+	keyResolversMutex.Lock()
+	keyResolvers[scheme] = resolver
+	keyResolversMutex.Unlock()
+
+	return nil
+}
+
+// ResolvePrivateKey returns the key material referenced by ref.
+//
+// Supported references are:
+//   - file:///path/to/key.pem reads the key from a file
+//   - env://VAR reads the key from an environment variable
+//   - base64://... decodes an inline base64-encoded key
+//   - <scheme>://... uses a resolver added with RegisterKeyResolver
+//
+// Any other value is treated as the key itself.
+func ResolvePrivateKey(ctx context.Context, ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+
+	switch {
+	case strings.HasPrefix(ref, fileKeyScheme):
+		path := strings.TrimPrefix(ref, fileKeyScheme)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read private key file: %w", err)
+		}
+		return string(data), nil
+
+	case strings.HasPrefix(ref, envKeyScheme):
+		name := strings.TrimPrefix(ref, envKeyScheme)
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+
+	case strings.HasPrefix(ref, base64KeyScheme):
+		encoded := strings.Join(strings.Fields(strings.TrimPrefix(ref, base64KeyScheme)), "")
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode base64 private key: %w", err)
+		}
+		return string(data), nil
+	}
+
+	if scheme, _, ok := strings.Cut(ref, "://"); ok && !strings.ContainsAny(scheme, " \n-") {
+		keyResolversMutex.RLock()
+		resolver, registered := keyResolvers[scheme]
+		keyResolversMutex.RUnlock()
+
+		if !registered {
+			return "", fmt.Errorf("no key resolver registered for scheme %q", scheme)
+		}
+
+		key, err := resolver.ResolveKey(ctx, ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve private key from %s: %w", scheme, err)
+		}
+		return key, nil
+	}
+
+	return ref, nil
+}
+
+// keyFilePath returns the file path of a file:// key reference
+func keyFilePath(ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if !strings.HasPrefix(ref, fileKeyScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(ref, fileKeyScheme), true
+}
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ghappauth/internal/metrics"
+	"ghappauth/internal/types"
+)
+
+func TestResolvePrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyPath, []byte(testPrivateKey), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	t.Setenv("GHAPPAUTH_TEST_KEY", testPrivateKey)
+
+	err := RegisterKeyResolver("testvault", KeyResolverFunc(func(ctx context.Context, ref string) (string, error) {
+		if ref != "testvault://apps/my-app" {
+			t.Errorf("Expected ref 'testvault://apps/my-app', got %s", ref)
+		}
+		return testPrivateKey, nil
+	}))
+	if err != nil {
+		t.Fatalf("RegisterKeyResolver() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ref     string
+		wantErr string
+	}{
+		{name: "raw PEM", ref: testPrivateKey},
+		{name: "file", ref: "file://" + keyPath},
+		{name: "missing file", ref: "file://" + filepath.Join(dir, "missing.pem"), wantErr: "failed to read private key file"},
+		{name: "env", ref: "env://GHAPPAUTH_TEST_KEY"},
+		{name: "unset env", ref: "env://GHAPPAUTH_TEST_MISSING", wantErr: "is not set"},
+		{name: "base64", ref: "base64://" + base64.StdEncoding.EncodeToString([]byte(testPrivateKey))},
+		{name: "invalid base64", ref: "base64://not base64!", wantErr: "failed to decode base64"},
+		{name: "registered resolver", ref: "testvault://apps/my-app"},
+		{name: "unregistered scheme", ref: "unknown://apps/my-app", wantErr: "no key resolver registered"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ResolvePrivateKey(context.Background(), tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolvePrivateKey() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolvePrivateKey() error = %v", err)
+			}
+			if key != testPrivateKey {
+				t.Error("ResolvePrivateKey() returned unexpected key material")
+			}
+		})
+	}
+}
+
+func TestRegisterKeyResolver_Invalid(t *testing.T) {
+	resolver := KeyResolverFunc(func(ctx context.Context, ref string) (string, error) {
+		return "", nil
+	})
+
+	if err := RegisterKeyResolver("", resolver); err == nil {
+		t.Error("RegisterKeyResolver() should reject an empty scheme")
+	}
+	if err := RegisterKeyResolver("vault", nil); err == nil {
+		t.Error("RegisterKeyResolver() should reject a nil resolver")
+	}
+	if err := RegisterKeyResolver("file", resolver); err == nil {
+		t.Error("RegisterKeyResolver() should not override the file scheme")
+	}
+}
+
+func TestGitHubAppAuth_WatchPrivateKey(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyPath, []byte(testPrivateKey), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     "file://" + keyPath,
+		InstallationID: "67890",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := auth.WatchPrivateKey(ctx, 10*time.Millisecond); err != nil {
+		t.Fatalf("WatchPrivateKey() error = %v", err)
+	}
+
+	before, err := auth.GetJWT()
+	if err != nil {
+		t.Fatalf("GetJWT() error = %v", err)
+	}
+
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	rotatedPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rotated),
+	})
+	if err := os.WriteFile(keyPath, rotatedPEM, 0600); err != nil {
+		t.Fatalf("Failed to rotate key file: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		current, _ := auth.keys.current()
+		if current.Equal(rotated) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("WatchPrivateKey() did not pick up the rotated key")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	after, err := auth.GetJWT()
+	if err != nil {
+		t.Fatalf("GetJWT() error = %v", err)
+	}
+	if before == after {
+		t.Error("GetJWT() should sign a new token after the key is rotated")
+	}
+}
+
+func TestGitHubAppAuth_WatchPrivateKey_NotFile(t *testing.T) {
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	if err := auth.WatchPrivateKey(context.Background(), time.Second); err == nil {
+		t.Error("WatchPrivateKey() should fail for keys not loaded from a file")
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to write from a background goroutine
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestGitHubAppAuth_WatchPrivateKey_InvalidKey(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyPath, []byte(testPrivateKey), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     "file://" + keyPath,
+		InstallationID: "67890",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+	var logs lockedBuffer
+	auth.SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+	registry := metrics.NewRegistry()
+	auth.SetMetrics(registry)
+	original, _ := auth.keys.current()
+
+	if err := auth.WatchPrivateKey(t.Context(), 5*time.Millisecond); err != nil {
+		t.Fatalf("WatchPrivateKey() error = %v", err)
+	}
+	if err := os.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(logs.String(), "failed to reload private key") {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the invalid key to be logged")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The unchanged file is not parsed again on later ticks
+	time.Sleep(50 * time.Millisecond)
+	var out strings.Builder
+	registry.WriteText(&out)
+	if !strings.Contains(out.String(), `ghappauth_key_reloads_total{result="failure"} 1`+"\n") {
+		t.Errorf("Expected one failed reload, got:\n%s", out.String())
+	}
+
+	if current, _ := auth.keys.current(); !current.Equal(original) {
+		t.Error("Expected the current key to be kept")
+	}
+}
+
+func TestGitHubAppAuth_SetPrivateKeysWhileWatched(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyPath, []byte(testPrivateKey), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     "file://" + keyPath,
+		InstallationID: "67890",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	if err := auth.WatchPrivateKey(ctx, time.Hour); err != nil {
+		t.Fatalf("WatchPrivateKey() error = %v", err)
+	}
+	if err := auth.WatchPrivateKey(ctx, time.Hour); err == nil {
+		t.Error("Expected a second watcher to be rejected")
+	}
+	if err := auth.SetPrivateKeys(testPrivateKey); err == nil {
+		t.Error("Expected SetPrivateKeys to fail while the key file is watched")
+	}
+
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for auth.SetPrivateKeys(testPrivateKey) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected SetPrivateKeys to work once the watcher stopped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ghappauth/internal/audit"
+	"ghappauth/internal/types"
+)
+
+// redacted replaces secrets in log output
+const redacted = "[REDACTED]"
+
+// discardLogger is used until a logger is set, so the library is silent by default
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// secretPattern matches GitHub tokens, JWTs and bearer credentials inside strings
+var secretPattern = regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9_]{16,}|github_pat_[A-Za-z0-9_]{20,}|eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)|(?i:bearer\s+)\S+`)
+
+// sensitiveKeys are attribute keys whose values are always redacted
+var sensitiveKeys = map[string]bool{
+	"authorization": true,
+	"token":         true,
+	"jwt":           true,
+	"password":      true,
+	"secret":        true,
+	"private_key":   true,
+}
+
+// NewRedactingHandler wraps handler so that Authorization headers, tokens,
+// JWTs and private keys never reach it. Loggers passed to SetLogger are
+// wrapped automatically.
+func NewRedactingHandler(handler slog.Handler) slog.Handler {
+	if _, ok := handler.(*redactingHandler); ok {
+		return handler
+	}
+	return &redactingHandler{handler: handler}
+}
+
+type redactingHandler struct {
+	handler slog.Handler
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redactedRecord := slog.NewRecord(record.Time, record.Level, redactString(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redactedRecord.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.handler.Handle(ctx, redactedRecord)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redactedAttrs[i] = redactAttr(attr)
+	}
+	return &redactingHandler{handler: h.handler.WithAttrs(redactedAttrs)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{handler: h.handler.WithGroup(name)}
+}
+
+// redactAttr removes secrets from an attribute and any attributes it groups
+func redactAttr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redacted)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		redactedGroup := make([]slog.Attr, len(group))
+		for i, member := range group {
+			redactedGroup[i] = redactAttr(member)
+		}
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(redactedGroup...)}
+	case slog.KindString:
+		return slog.String(attr.Key, redactString(attr.Value.String()))
+	case slog.KindAny:
+		switch value := attr.Value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, redactString(value.Error()))
+		case http.Header:
+			return slog.Any(attr.Key, redactHeader(value))
+		}
+	}
+
+	return attr
+}
+
+// redactString replaces tokens and bearer credentials in s
+func redactString(s string) string {
+	return secretPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if fields := strings.Fields(match); len(fields) == 2 {
+			return fields[0] + " " + redacted
+		}
+		return redacted
+	})
+}
+
+// redactHeader returns a copy of header with credentials removed
+func redactHeader(header http.Header) http.Header {
+	clone := header.Clone()
+	for _, name := range []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"} {
+		if clone.Get(name) != "" {
+			clone.Set(name, redacted)
+		}
+	}
+	return clone
+}
+
+// SetLogger sets the logger for token and key events and for the requests
+// made to GitHub. Credentials are redacted from everything logged. A nil
+// logger disables logging.
+func (g *GitHubAppAuth) SetLogger(logger *slog.Logger) {
+	g.httpClient.SetLogger(logger)
+	g.logger = g.httpClient.logger
+}
+
+// tokenLogAttrs describes a token for logging without revealing it
+func tokenLogAttrs(installationID string, token *types.GitHubAppToken) []any {
+	return []any{
+		"installation_id", installationID,
+		"fingerprint", audit.Fingerprint(token.Token),
+		"expires_at", token.ExpiresAt,
+	}
+}
+
+// rateLimit is the rate limit state GitHub reports on each response
+type rateLimit struct {
+	limit     int
+	remaining int
+	reset     time.Time
+	resource  string
+}
+
+// parseRateLimit reads the X-RateLimit-* headers, reporting false when they are absent
+func parseRateLimit(header http.Header) (rateLimit, bool) {
+	limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return rateLimit{}, false
+	}
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return rateLimit{}, false
+	}
+
+	state := rateLimit{limit: limit, remaining: remaining, resource: header.Get("X-RateLimit-Resource")}
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		state.reset = time.Unix(reset, 0)
+	}
+
+	return state, true
+}
+
+// low reports whether less than a tenth of the rate limit is left
+func (r rateLimit) low() bool {
+	return r.remaining*10 < r.limit
+}
+
+// logAttrs returns the rate limit as log attributes
+func (r rateLimit) logAttrs() []any {
+	return []any{
+		slog.Int("rate_limit", r.limit),
+		slog.Int("rate_remaining", r.remaining),
+		slog.Time("rate_reset", r.reset),
+		slog.String("rate_resource", r.resource),
+	}
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"net/url"
+
+	"ghappauth/internal/metrics"
+)
+
+// SetMetrics sets where token mints, renewals, cache lookups and the requests
+// made to GitHub are measured. A nil recorder disables metrics.
+func (g *GitHubAppAuth) SetMetrics(recorder metrics.Recorder) {
+	g.httpClient.SetMetrics(recorder)
+	g.metrics = g.httpClient.metrics
+}
+
+// SetMetrics sets where request latencies, retries and rate limits are
+// measured. A nil recorder disables metrics.
+func (c *HTTPClient) SetMetrics(recorder metrics.Recorder) {
+	if recorder == nil {
+		recorder = metrics.Discard
+	}
+	c.metrics = recorder
+}
+
+// endpointLabel returns the endpoint of a request for metrics, falling back
+// to the URL path when the request does not name its route template
+func endpointLabel(config *RequestConfig) string {
+	if config.Endpoint != "" {
+		return config.Endpoint
+	}
+	if parsed, err := url.Parse(config.URL); err == nil {
+		return parsed.Path
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"ghappauth/internal/audit"
+	"ghappauth/internal/metrics"
+	"ghappauth/internal/types"
+)
+
+// Option configures a GitHubAppAuth created by New or NewApp, or a
+// TokenManager created by NewManager. Each option documents what it applies
+// to; options that do not apply to what is being created are ignored.
+type Option func(*options)
+
+// options collects the settings of the Option values passed to a constructor
+type options struct {
+	httpClient       *HTTPClient
+	standardClient   *http.Client
+	transport        http.RoundTripper
+	userAgent        string
+	logger           *slog.Logger
+	metrics          metrics.Recorder
+	tracerProvider   trace.TracerProvider
+	auditSink        audit.Sink
+	tokenPolicy      TokenPolicy
+	clock            func() time.Time
+	jwtRefreshMargin time.Duration
+	cache            *TokenCache
+	renewBuffer      time.Duration
+	staleWhileError  bool
+	maxCachedTokens  *int
+}
+
+func collectOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+	return o
+}
+
+// WithHTTPClient sends GitHub API requests with client. Applies to GitHubAppAuth.
+func WithHTTPClient(client *HTTPClient) Option {
+	return func(o *options) { o.httpClient = client }
+}
+
+// WithStandardClient sends GitHub API requests with an http.Client, keeping
+// the retry, circuit breaker and observability settings. Applies to GitHubAppAuth.
+func WithStandardClient(client *http.Client) Option {
+	return func(o *options) { o.standardClient = client }
+}
+
+// WithTransport sends GitHub API requests through transport. Applies to GitHubAppAuth.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) { o.transport = transport }
+}
+
+// WithUserAgent sets the User-Agent header of GitHub API requests. Applies to GitHubAppAuth.
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) { o.userAgent = userAgent }
+}
+
+// WithLogger sets the logger. For a TokenManager it overrides the logger of
+// its GitHubAppAuth. Applies to GitHubAppAuth and TokenManager.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithMetrics sets where activity is measured. Applies to GitHubAppAuth.
+func WithMetrics(recorder metrics.Recorder) Option {
+	return func(o *options) { o.metrics = recorder }
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider. Applies to GitHubAppAuth.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *options) { o.tracerProvider = provider }
+}
+
+// WithAuditSink sets where token events are recorded. Applies to GitHubAppAuth.
+func WithAuditSink(sink audit.Sink) Option {
+	return func(o *options) { o.auditSink = sink }
+}
+
+// WithTokenPolicy sets the policy checked before installation tokens are
+// created. Applies to GitHubAppAuth.
+func WithTokenPolicy(policy TokenPolicy) Option {
+	return func(o *options) { o.tokenPolicy = policy }
+}
+
+// WithClock sets the function returning the current time, used for JWT
+// claims and token expiry. A TokenManager uses the clock of its GitHubAppAuth
+// unless given its own. Applies to GitHubAppAuth and TokenManager.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) { o.clock = now }
+}
+
+// WithJWTRefreshMargin sets how long before expiry a cached JWT is replaced.
+// Applies to GitHubAppAuth.
+func WithJWTRefreshMargin(margin time.Duration) Option {
+	return func(o *options) { o.jwtRefreshMargin = margin }
+}
+
+// WithCache stores tokens in cache, which may be shared by the token managers
+// of one GitHubAppAuth. Applies to TokenManager.
+func WithCache(cache *TokenCache) Option {
+	return func(o *options) { o.cache = cache }
+}
+
+// WithRenewBuffer sets how long before expiry a cached token is renewed
+// (default 5m). Applies to TokenManager.
+func WithRenewBuffer(buffer time.Duration) Option {
+	return func(o *options) { o.renewBuffer = buffer }
+}
+
+// WithStaleWhileError serves cached tokens whose renewal failed until they
+// expire, as SetStaleWhileError does. Applies to TokenManager.
+func WithStaleWhileError() Option {
+	return func(o *options) { o.staleWhileError = true }
+}
+
+// WithMaxCachedTokens bounds the number of cached tokens, evicting the least
+// recently used ones beyond it, as SetMaxCachedTokens does. Without it the
+// cache holds at most DefaultMaxCachedTokens tokens; 0 removes the bound.
+// Applies to TokenManager.
+func WithMaxCachedTokens(maxEntries int) Option {
+	return func(o *options) { o.maxCachedTokens = &maxEntries }
+}
+
+// New creates a GitHub App authentication instance configured by opts
+func New(config *types.GitHubAppConfig, opts ...Option) (*GitHubAppAuth, error) {
+	return newGitHubAppAuth(config, true, collectOptions(opts))
+}
+
+// NewApp creates a GitHub App authentication instance for App-level
+// endpoints configured by opts. Unlike New the installation_id is optional.
+func NewApp(config *types.GitHubAppConfig, opts ...Option) (*GitHubAppAuth, error) {
+	return newGitHubAppAuth(config, false, collectOptions(opts))
+}
+
+// NewManager creates a token manager for auth configured by opts. Its cache
+// holds at most DefaultMaxCachedTokens tokens unless WithMaxCachedTokens says
+// otherwise. Expired tokens are dropped when requested again or evicted; no
+// goroutine removes them unless SweepCache is started.
+func NewManager(auth *GitHubAppAuth, opts ...Option) *TokenManager {
+	o := collectOptions(opts)
+
+	tm := &TokenManager{
+		auth:            auth,
+		cache:           o.cache,
+		renewBuffer:     o.renewBuffer,
+		clock:           o.clock,
+		staleWhileError: o.staleWhileError,
+	}
+	if tm.cache == nil {
+		tm.cache = NewTokenCache()
+	}
+	if tm.renewBuffer == 0 {
+		tm.renewBuffer = 5 * time.Minute // Default 5 minutes buffer
+	}
+	if o.logger != nil {
+		tm.SetLogger(o.logger)
+	}
+	if o.maxCachedTokens != nil {
+		tm.SetMaxCachedTokens(*o.maxCachedTokens)
+	}
+
+	return tm
+}
+
+// apply configures g with the options that apply to a GitHubAppAuth
+func (o *options) apply(g *GitHubAppAuth) {
+	// The HTTP client comes first so the settings below also apply to it
+	if o.httpClient != nil {
+		g.SetHTTPClient(o.httpClient)
+	}
+	if o.standardClient != nil {
+		g.SetStandardClient(o.standardClient)
+	}
+	if o.transport != nil {
+		g.SetTransport(o.transport)
+	}
+	if o.userAgent != "" {
+		g.httpClient.SetUserAgent(o.userAgent)
+	}
+
+	if o.logger != nil {
+		g.SetLogger(o.logger)
+	}
+	if o.metrics != nil {
+		g.SetMetrics(o.metrics)
+	}
+	if o.tracerProvider != nil {
+		g.SetTracerProvider(o.tracerProvider)
+	}
+	if o.auditSink != nil {
+		g.SetAuditSink(o.auditSink)
+	}
+	if o.tokenPolicy != nil {
+		g.SetTokenPolicy(o.tokenPolicy)
+	}
+	if o.clock != nil {
+		g.clock = o.clock
+	}
+	if o.jwtRefreshMargin > 0 {
+		g.SetJWTRefreshMargin(o.jwtRefreshMargin)
+	}
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"ghappauth/internal/metrics"
+	"ghappauth/internal/types"
+)
+
+func TestNew_Options(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{Token: "ghs_options"})
+	}))
+	defer server.Close()
+
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	transport := &countingTransport{}
+	registry := metrics.NewRegistry()
+
+	auth, err := New(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	},
+		WithHTTPClient(NewHTTPClient(&HTTPClientConfig{MaxRetries: 1})),
+		WithTransport(transport),
+		WithUserAgent("dashboard/2.0"),
+		WithMetrics(registry),
+		WithClock(func() time.Time { return now }),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := auth.CreateInstallationToken(t.Context(), "67890", nil); err != nil {
+		t.Fatalf("CreateInstallationToken() error = %v", err)
+	}
+	if transport.requests != 1 {
+		t.Errorf("Expected 1 request through the transport, got %d", transport.requests)
+	}
+	if userAgent != "dashboard/2.0" {
+		t.Errorf("Expected the configured User-Agent, got %q", userAgent)
+	}
+	if auth.httpClient.metrics != metrics.Recorder(registry) {
+		t.Error("Expected the HTTP client to use the metrics")
+	}
+
+	signed, err := auth.GenerateJWT()
+	if err != nil {
+		t.Fatalf("GenerateJWT() error = %v", err)
+	}
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(signed, claims); err != nil {
+		t.Fatalf("Failed to parse JWT: %v", err)
+	}
+	if !claims.IssuedAt.Equal(now) {
+		t.Errorf("Expected the JWT to be issued at %v, got %v", now, claims.IssuedAt)
+	}
+}
+
+func TestNewManager_Options(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{
+			Token:     "ghs_shared",
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+	}))
+	defer server.Close()
+
+	auth, err := New(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	cache := NewTokenCache()
+	first := NewManager(auth, WithCache(cache), WithRenewBuffer(10*time.Minute))
+	second := NewManager(auth, WithCache(cache))
+
+	if first.GetRenewBuffer() != 10*time.Minute || second.GetRenewBuffer() != 5*time.Minute {
+		t.Errorf("Unexpected renew buffers %v and %v", first.GetRenewBuffer(), second.GetRenewBuffer())
+	}
+
+	if _, err := first.GetToken(); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if _, err := second.GetToken(); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if requests != 1 || cache.Len() != 1 {
+		t.Errorf("Expected the token to be shared, got %d requests and %d cached", requests, cache.Len())
+	}
+
+	// A manager whose clock is past the token expiry renews it
+	later := NewManager(auth, WithCache(cache), WithClock(func() time.Time { return time.Now().Add(2 * time.Hour) }))
+	if _, err := later.GetToken(); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected a renewal, got %d requests", requests)
+	}
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+)
+
+// minRSAKeyBits is the smallest RSA key size accepted for signing JWTs
+const minRSAKeyBits = 2048
+
+// parsePrivateKey parses a PEM-encoded RSA private key.
+//
+// The key may be PKCS#1 (RSA PRIVATE KEY), PKCS#8 (PRIVATE KEY) or
+// passphrase-protected PKCS#8 (ENCRYPTED PRIVATE KEY). Keys copied through
+// environment variables are also accepted with escaped "\n" sequences or as
+// a base64-encoded PEM document.
+func parsePrivateKey(privateKeyPEM string, passphrase string) (*rsa.PrivateKey, error) {
+	data, err := normalizePrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block")
+	}
+
+	rest = bytes.TrimSpace(rest)
+	if len(rest) > 0 {
+		if next, _ := pem.Decode(rest); next != nil {
+			return nil, fmt.Errorf("expected a single PEM block, found additional %s block", next.Type)
+		}
+		return nil, fmt.Errorf("unexpected trailing data after PEM block")
+	}
+
+	var key interface{}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		if x509.IsEncryptedPEMBlock(block) {
+			return nil, fmt.Errorf("legacy encrypted PEM keys are not supported, convert to encrypted PKCS8")
+		}
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RSA private key: %w", err)
+		}
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PKCS8 private key: %w", err)
+		}
+	case "ENCRYPTED PRIVATE KEY":
+		if passphrase == "" {
+			return nil, fmt.Errorf("private key is encrypted but no passphrase was provided")
+		}
+		key, err = pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt PKCS8 private key: %w", err)
+		}
+	case "EC PRIVATE KEY", "OPENSSH PRIVATE KEY":
+		return nil, fmt.Errorf("unsupported private key type: %s (GitHub Apps require an RSA key)", block.Type)
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %s", block.Type)
+	}
+
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key is not an RSA private key (got %T)", key)
+	}
+
+	if bits := privateKey.N.BitLen(); bits < minRSAKeyBits {
+		return nil, fmt.Errorf("RSA key size %d bits is below the minimum of %d bits", bits, minRSAKeyBits)
+	}
+
+	return privateKey, nil
+}
+
+// normalizePrivateKey undoes common mangling of keys stored in environment
+// variables and secret stores: surrounding whitespace or quotes, escaped
+// newlines and base64-encoded PEM documents
+func normalizePrivateKey(privateKey string) ([]byte, error) {
+	key := strings.TrimSpace(privateKey)
+	key = strings.Trim(key, `"'`)
+
+	if strings.Contains(key, "-----BEGIN") {
+		key = strings.ReplaceAll(key, `\r\n`, "\n")
+		key = strings.ReplaceAll(key, `\n`, "\n")
+		key = strings.ReplaceAll(key, "\r\n", "\n")
+		return []byte(key), nil
+	}
+
+	compact := strings.Join(strings.Fields(key), "")
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		decoded, err := encoding.DecodeString(compact)
+		if err == nil && bytes.Contains(decoded, []byte("-----BEGIN")) {
+			return normalizePrivateKey(string(decoded))
+		}
+	}
+
+	return nil, fmt.Errorf("failed to decode PEM block")
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/youmark/pkcs8"
+)
+
+func TestParsePrivateKey(t *testing.T) {
+	testKey, err := parsePrivateKey(testPrivateKey, "")
+	if err != nil {
+		t.Fatalf("Failed to parse test key: %v", err)
+	}
+
+	pkcs1PEM := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(testKey),
+	}))
+
+	encryptedDER, err := pkcs8.ConvertPrivateKeyToPKCS8(testKey, []byte("s3cret"))
+	if err != nil {
+		t.Fatalf("Failed to encrypt test key: %v", err)
+	}
+	encryptedPEM := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "ENCRYPTED PRIVATE KEY",
+		Bytes: encryptedDER,
+	}))
+
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate small key: %v", err)
+	}
+	smallPEM := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(smallKey),
+	}))
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	ecDER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal EC key: %v", err)
+	}
+	ecPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecDER}))
+
+	tests := []struct {
+		name       string
+		key        string
+		passphrase string
+		wantErr    string
+	}{
+		{name: "PKCS8", key: testPrivateKey},
+		{name: "PKCS1", key: pkcs1PEM},
+		{name: "surrounding whitespace and quotes", key: "\n  \"" + testPrivateKey + "\"\n"},
+		{name: "escaped newlines", key: strings.ReplaceAll(testPrivateKey, "\n", `\n`)},
+		{name: "CRLF line endings", key: strings.ReplaceAll(testPrivateKey, "\n", "\r\n")},
+		{name: "base64-encoded PEM", key: base64.StdEncoding.EncodeToString([]byte(testPrivateKey))},
+		{name: "wrapped base64-encoded PEM", key: wrapLines(base64.StdEncoding.EncodeToString([]byte(testPrivateKey)), 64)},
+		{name: "encrypted PKCS8", key: encryptedPEM, passphrase: "s3cret"},
+		{name: "encrypted PKCS8 without passphrase", key: encryptedPEM, wantErr: "no passphrase"},
+		{name: "encrypted PKCS8 with wrong passphrase", key: encryptedPEM, passphrase: "wrong", wantErr: "failed to decrypt"},
+		{name: "key below minimum size", key: smallPEM, wantErr: "below the minimum"},
+		{name: "non-RSA key", key: ecPEM, wantErr: "not an RSA private key"},
+		{name: "multiple PEM blocks", key: testPrivateKey + "\n" + pkcs1PEM, wantErr: "single PEM block"},
+		{name: "trailing data", key: testPrivateKey + "\ngarbage", wantErr: "trailing data"},
+		{name: "not a key", key: "invalid-key", wantErr: "failed to decode PEM block"},
+		{name: "unsupported block type", key: "-----BEGIN CERTIFICATE-----\nMA==\n-----END CERTIFICATE-----", wantErr: "unsupported private key type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parsePrivateKey(tt.key, tt.passphrase)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parsePrivateKey() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePrivateKey() error = %v", err)
+			}
+			if !key.Equal(testKey) {
+				t.Error("parsePrivateKey() returned a different key")
+			}
+		})
+	}
+}
+
+// wrapLines splits s into lines of at most width characters
+func wrapLines(s string, width int) string {
+	var b strings.Builder
+	for len(s) > width {
+		b.WriteString(s[:width])
+		b.WriteString("\n")
+		s = s[width:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
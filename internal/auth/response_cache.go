@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ResponseCacheConfig enables a cache of GET responses that carry an ETag or
+// Last-Modified header. Cached responses are revalidated with If-None-Match
+// or If-Modified-Since on every request; GitHub answers 304 Not Modified,
+// which does not count against the rate limit, and the cached body is used.
+type ResponseCacheConfig struct {
+	// TTL is how long a response is kept after it was last validated (default 1h)
+	TTL time.Duration
+	// MaxEntries bounds the number of cached responses (default 256)
+	MaxEntries int
+	// MaxBytes bounds the total size of the cached bodies (default 8 MiB).
+	// Larger responses are not cached.
+	MaxBytes int64
+}
+
+// responseCache is an LRU cache of responses by request and credential. A nil
+// cache caches nothing.
+type responseCache struct {
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Front is the most recently used *cachedResponse
+	size    int64
+	now     func() time.Time
+}
+
+// cachedResponse is a response body together with its validators
+type cachedResponse struct {
+	key          string
+	status       int
+	header       http.Header
+	body         []byte
+	etag         string
+	lastModified string
+	validatedAt  time.Time
+}
+
+func newResponseCache(config *ResponseCacheConfig) *responseCache {
+	if config == nil {
+		return nil
+	}
+
+	cache := &responseCache{
+		ttl:        config.TTL,
+		maxEntries: config.MaxEntries,
+		maxBytes:   config.MaxBytes,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+	if cache.ttl <= 0 {
+		cache.ttl = time.Hour
+	}
+	if cache.maxEntries <= 0 {
+		cache.maxEntries = 256
+	}
+	if cache.maxBytes <= 0 {
+		cache.maxBytes = 8 << 20
+	}
+
+	return cache
+}
+
+// responseCacheKey identifies a request and the credential it is made with,
+// without keeping the credential itself
+func responseCacheKey(config *RequestConfig) string {
+	credential := config.CacheCredential
+	if credential == "" {
+		credential = config.AuthToken
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s", config.URL, config.Accept, credential)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// lookup returns the key of a cacheable request and its cached response, if any
+func (c *responseCache) lookup(config *RequestConfig) (string, *cachedResponse) {
+	if c == nil || (config.Method != "" && config.Method != http.MethodGet) {
+		return "", nil
+	}
+
+	key := responseCacheKey(config)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return key, nil
+	}
+
+	cached := element.Value.(*cachedResponse)
+	if c.now().Sub(cached.validatedAt) > c.ttl {
+		c.remove(element)
+		return key, nil
+	}
+
+	c.lru.MoveToFront(element)
+	return key, cached
+}
+
+// setConditionalHeaders asks GitHub to answer 304 if the cached response is still current
+func (r *cachedResponse) setConditionalHeaders(req *http.Request) {
+	if r == nil {
+		return
+	}
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+	if r.lastModified != "" {
+		req.Header.Set("If-Modified-Since", r.lastModified)
+	}
+}
+
+// resolve returns the response to hand to the caller: the cached response,
+// reporting true, when GitHub answered 304, or resp after caching it when it
+// has validators
+func (c *responseCache) resolve(key string, cached *cachedResponse, resp *http.Response) (*http.Response, bool, error) {
+	if c == nil || key == "" {
+		return resp, false, nil
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+
+		c.mutex.Lock()
+		cached.validatedAt = c.now()
+		c.mutex.Unlock()
+
+		// The 304 carries current rate limit headers; the rest come from the cache
+		header := cached.header.Clone()
+		for name, values := range resp.Header {
+			header[name] = values
+		}
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", cached.status, http.StatusText(cached.status)),
+			StatusCode:    cached.status,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(cached.body)),
+			ContentLength: int64(len(cached.body)),
+			Request:       resp.Request,
+		}, true, nil
+	}
+
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if resp.StatusCode != http.StatusOK || (etag == "" && lastModified == "") {
+		return resp, false, nil
+	}
+
+	// Read at most one byte past the limit to tell whether the body fits
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxBytes+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, false, fmt.Errorf("failed to read response: %w", err)
+	}
+	if int64(len(body)) > c.maxBytes {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, false, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	c.store(&cachedResponse{
+		key:          key,
+		status:       resp.StatusCode,
+		header:       resp.Header.Clone(),
+		body:         body,
+		etag:         etag,
+		lastModified: lastModified,
+	})
+	return resp, false, nil
+}
+
+// store caches a response, evicting the least recently used ones beyond the bounds
+func (c *responseCache) store(response *cachedResponse) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	response.validatedAt = c.now()
+	if element, ok := c.entries[response.key]; ok {
+		c.remove(element)
+	}
+
+	c.entries[response.key] = c.lru.PushFront(response)
+	c.size += int64(len(response.body))
+
+	for c.lru.Len() > c.maxEntries || c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops a cached response; callers must hold mutex
+func (c *responseCache) remove(element *list.Element) {
+	response := c.lru.Remove(element).(*cachedResponse)
+	delete(c.entries, response.key)
+	c.size -= int64(len(response.body))
+}
+
+// count returns the number of cached responses
+func (c *responseCache) count() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.lru.Len()
+}
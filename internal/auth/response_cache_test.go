@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ghappauth/internal/types"
+)
+
+// etagServer serves body with an ETag, answering 304 when the client already has it
+func etagServer(t *testing.T, body string, full, notModified *int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + r.URL.Path + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			*notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		*full++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGitHubAppAuth_ResponseCache(t *testing.T) {
+	var full, notModified int
+	server := etagServer(t, `{"id": 1, "slug": "my-app", "name": "My App"}`, &full, &notModified)
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+	auth.SetHTTPClient(NewHTTPClient(&HTTPClientConfig{
+		MaxRetries:    1,
+		ResponseCache: &ResponseCacheConfig{},
+	}))
+
+	for i := 0; i < 3; i++ {
+		// A new JWT for each call still finds the cached response
+		auth.InvalidateJWT()
+
+		app, err := auth.GetAppInfo()
+		if err != nil {
+			t.Fatalf("GetAppInfo() error = %v", err)
+		}
+		if app.Slug != "my-app" {
+			t.Errorf("Expected the app from the cached response, got %+v", app)
+		}
+	}
+
+	if full != 1 || notModified != 2 {
+		t.Errorf("Expected 1 full response and 2 revalidations, got %d and %d", full, notModified)
+	}
+}
+
+func TestHTTPClient_ResponseCacheBounds(t *testing.T) {
+	var full, notModified int
+	server := etagServer(t, `{"value": "`+strings.Repeat("x", 100)+`"}`, &full, &notModified)
+
+	client := NewHTTPClient(&HTTPClientConfig{
+		MaxRetries:    1,
+		ResponseCache: &ResponseCacheConfig{TTL: time.Minute, MaxEntries: 1},
+	})
+	now := time.Now()
+	client.responses.now = func() time.Time { return now }
+
+	get := func(client *HTTPClient, path string) {
+		t.Helper()
+		err := client.DoRequest(t.Context(), &RequestConfig{
+			Method:         http.MethodGet,
+			URL:            server.URL + path,
+			AuthToken:      "ghs_token",
+			ExpectedStatus: http.StatusOK,
+		}, nil)
+		if err != nil {
+			t.Fatalf("DoRequest(%s) error = %v", path, err)
+		}
+	}
+
+	get(client, "/a")
+	get(client, "/a")
+	if full != 1 || notModified != 1 {
+		t.Fatalf("Expected a revalidation, got %d full and %d not modified", full, notModified)
+	}
+
+	// Only one response fits, so caching /b evicts /a
+	get(client, "/b")
+	get(client, "/a")
+	if full != 3 || client.responses.count() != 1 {
+		t.Errorf("Expected /a to be evicted, got %d full responses and %d cached", full, client.responses.count())
+	}
+
+	// Responses are dropped once the TTL has passed since they were validated
+	now = now.Add(2 * time.Minute)
+	get(client, "/a")
+	if full != 4 {
+		t.Errorf("Expected an expired response to be fetched again, got %d full responses", full)
+	}
+
+	// Responses larger than MaxBytes are not cached
+	small := NewHTTPClient(&HTTPClientConfig{
+		MaxRetries:    1,
+		ResponseCache: &ResponseCacheConfig{MaxBytes: 10},
+	})
+	get(small, "/c")
+	get(small, "/c")
+	if full != 6 || small.responses.count() != 0 {
+		t.Errorf("Expected large responses not to be cached, got %d full responses and %d cached", full, small.responses.count())
+	}
+}
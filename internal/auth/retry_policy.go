@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RetryPolicy decides which failed request attempts are retried and how long
+// to wait between them. HTTPClientConfig.MaxRetries still bounds the number of
+// attempts, and requests that are not idempotent are only retried when their
+// RequestConfig is marked RetrySafe.
+type RetryPolicy interface {
+	// Retryable reports whether an attempt is worth retrying. resp is nil
+	// when no response was received, in which case err is a *TransportError.
+	Retryable(resp *http.Response, err error) bool
+	// Delay returns how long to wait before retry n, counting from 1
+	Delay(n uint) time.Duration
+	// MaxElapsed returns how long after the first attempt retries may still
+	// start; 0 means no limit
+	MaxElapsed() time.Duration
+}
+
+// BackoffPolicy is the default RetryPolicy. It retries 408, 429 and 5xx
+// responses and transient network errors, waiting a random time between zero
+// and an exponentially growing delay ("full jitter").
+type BackoffPolicy struct {
+	// BaseDelay is the upper bound of the first wait
+	BaseDelay time.Duration
+	// Multiplier grows the upper bound after each retry; values below 1 mean 2
+	Multiplier float64
+	// MaxDelay caps the upper bound of each wait; 0 means no cap
+	MaxDelay time.Duration
+	// MaxElapsedTime stops retrying once this long has passed since the first
+	// attempt; 0 means no limit
+	MaxElapsedTime time.Duration
+	// NoJitter waits the full delay instead of a random part of it
+	NoJitter bool
+}
+
+// NewBackoffPolicy returns the BackoffPolicy described by the retry settings of config
+func NewBackoffPolicy(config *HTTPClientConfig) *BackoffPolicy {
+	return &BackoffPolicy{
+		BaseDelay:      config.RetryDelay,
+		Multiplier:     config.BackoffMultiplier,
+		MaxDelay:       config.MaxRetryDelay,
+		MaxElapsedTime: config.MaxElapsedTime,
+	}
+}
+
+// Retryable implements RetryPolicy
+func (p *BackoffPolicy) Retryable(resp *http.Response, err error) bool {
+	if resp != nil {
+		return shouldRetry(resp.StatusCode)
+	}
+	return isTransientNetworkError(err)
+}
+
+// Delay implements RetryPolicy. n of 0 is treated as 1, and delays that
+// would overflow a time.Duration are capped at its maximum.
+func (p *BackoffPolicy) Delay(n uint) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(max(n, 1)-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	// float64(math.MaxInt64) rounds up to 2^63, which does not fit in an
+	// int64, so only delays below it are converted
+	limit := time.Duration(math.MaxInt64)
+	if delay < float64(math.MaxInt64) {
+		limit = time.Duration(delay)
+	}
+
+	if p.NoJitter || limit < 1 {
+		return limit
+	}
+	// As a uint64, limit+1 cannot overflow and the result fits in a Duration
+	return time.Duration(rand.Uint64N(uint64(limit) + 1))
+}
+
+// MaxElapsed implements RetryPolicy
+func (p *BackoffPolicy) MaxElapsed() time.Duration {
+	return p.MaxElapsedTime
+}
+
+// isTransientNetworkError reports whether a request failed in a way that
+// sending it again may fix, such as a reset connection or a timeout
+func isTransientNetworkError(err error) bool {
+	return err != nil && transportErrorKind(err) != ""
+}
+
+// idempotentMethods are the methods that can be sent again without changing the result
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// retryAllowed reports whether a request may be sent more than once
+func retryAllowed(config *RequestConfig) bool {
+	method := strings.ToUpper(config.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	return config.RetrySafe || idempotentMethods[method]
+}
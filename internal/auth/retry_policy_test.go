@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicy_Delay(t *testing.T) {
+	policy := &BackoffPolicy{BaseDelay: 100 * time.Millisecond, Multiplier: 3, MaxDelay: time.Second, NoJitter: true}
+
+	want := []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second}
+	for i, expected := range want {
+		if got := policy.Delay(uint(i + 1)); got != expected {
+			t.Errorf("Delay(%d) = %v, want %v", i+1, got, expected)
+		}
+	}
+
+	policy = &BackoffPolicy{BaseDelay: 100 * time.Millisecond, NoJitter: true}
+	if got := policy.Delay(3); got != 400*time.Millisecond {
+		t.Errorf("Expected a default multiplier of 2, got Delay(3) = %v", got)
+	}
+
+	policy = &BackoffPolicy{BaseDelay: 100 * time.Millisecond, Multiplier: 2, MaxDelay: 250 * time.Millisecond}
+	for n := uint(1); n <= 10; n++ {
+		if got := policy.Delay(n); got < 0 || got > 250*time.Millisecond {
+			t.Errorf("Delay(%d) = %v, want a jittered delay within [0, 250ms]", n, got)
+		}
+	}
+}
+
+func TestBackoffPolicy_DelayBounds(t *testing.T) {
+	uncapped := BackoffPolicy{BaseDelay: time.Second, Multiplier: 2}
+	fixed := uncapped
+	fixed.NoJitter = true
+
+	tests := []struct {
+		name   string
+		policy BackoffPolicy
+		n      uint
+		min    time.Duration
+		max    time.Duration
+	}{
+		{name: "zero counts as first retry", policy: fixed, n: 0, min: time.Second, max: time.Second},
+		{name: "zero with jitter", policy: uncapped, n: 0, min: 0, max: time.Second},
+		{name: "overflow without jitter", policy: fixed, n: 64, min: math.MaxInt64, max: math.MaxInt64},
+		{name: "overflow 35", policy: uncapped, n: 35, min: 0, max: math.MaxInt64},
+		{name: "overflow 40", policy: uncapped, n: 40, min: 0, max: math.MaxInt64},
+		{name: "overflow 64", policy: uncapped, n: 64, min: 0, max: math.MaxInt64},
+		{name: "infinite growth", policy: fixed, n: math.MaxUint32, min: math.MaxInt64, max: math.MaxInt64},
+		{name: "no base delay", policy: BackoffPolicy{Multiplier: 2}, n: 2000, min: 0, max: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 {
+				if got := tt.policy.Delay(tt.n); got < tt.min || got > tt.max {
+					t.Fatalf("Delay(%d) = %v, want within [%v, %v]", tt.n, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffPolicy_Retryable(t *testing.T) {
+	policy := NewBackoffPolicy(DefaultHTTPClientConfig())
+
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		want   bool
+	}{
+		{name: "server error", status: http.StatusBadGateway, want: true},
+		{name: "rate limited", status: http.StatusTooManyRequests, want: true},
+		{name: "not found", status: http.StatusNotFound, want: false},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, want: true},
+		{name: "TLS handshake timeout", err: tlsHandshakeTimeout{}, want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "malformed URL", err: errors.New("unsupported protocol scheme"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.status != 0 {
+				resp = &http.Response{StatusCode: tt.status}
+			}
+			if got := policy.Retryable(resp, tt.err); got != tt.want {
+				t.Errorf("Retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// tlsHandshakeTimeout mimics the unexported error net/http returns
+type tlsHandshakeTimeout struct{}
+
+func (tlsHandshakeTimeout) Error() string   { return "net/http: TLS handshake timeout" }
+func (tlsHandshakeTimeout) Timeout() bool   { return true }
+func (tlsHandshakeTimeout) Temporary() bool { return true }
+
+func TestHTTPClient_doRequest_NonIdempotent(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(&HTTPClientConfig{MaxRetries: 3, RetryDelay: time.Millisecond})
+
+	if _, err := client.doRequest(context.Background(), &RequestConfig{Method: "POST", URL: server.URL}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected a POST to be sent once, got %d attempts", attempts)
+	}
+
+	attempts = 0
+	if _, err := client.doRequest(context.Background(), &RequestConfig{Method: "POST", URL: server.URL, RetrySafe: true}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if attempts != 3 {
+		t.Errorf("Expected a retry-safe POST to be sent 3 times, got %d attempts", attempts)
+	}
+}
+
+func TestHTTPClient_doRequest_MaxElapsedTime(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(&HTTPClientConfig{
+		MaxRetries:  10,
+		RetryPolicy: &BackoffPolicy{BaseDelay: 50 * time.Millisecond, MaxElapsedTime: 120 * time.Millisecond, NoJitter: true},
+	})
+
+	_, err := client.doRequest(context.Background(), &RequestConfig{Method: "GET", URL: server.URL})
+	var retryable *RetryableError
+	if !errors.As(err, &retryable) || retryable.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the last status in the error, got %v", err)
+	}
+	// Waits of 50ms and 100ms fit in 120ms only once
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestHTTPClient_doRequest_RetryOnConnectionReset(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Hijack() error = %v", err)
+				return
+			}
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(&HTTPClientConfig{MaxRetries: 3, RetryDelay: time.Millisecond})
+
+	resp, err := client.doRequest(context.Background(), &RequestConfig{Method: "GET", URL: server.URL})
+	if err != nil {
+		t.Fatalf("doRequest() error = %v", err)
+	}
+	resp.Body.Close()
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestHTTPClient_doRequest_CanceledDuringBackoff(t *testing.T) {
+	// Nothing listens on the address of a closed server, so every attempt fails to connect
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	client := NewHTTPClient(&HTTPClientConfig{
+		MaxRetries:  3,
+		RetryPolicy: &BackoffPolicy{BaseDelay: time.Minute, NoJitter: true},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := client.doRequest(ctx, &RequestConfig{Method: "GET", URL: url})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Expected the transport error of the last attempt, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"ghappauth/internal/audit"
+	"ghappauth/internal/types"
+)
+
+// Backoff between renewal attempts of a token served stale, doubling after each failure
+const (
+	staleRenewalMinBackoff = 10 * time.Second
+	staleRenewalMaxBackoff = 2 * time.Minute
+)
+
+// StaleTokenHook is called when a renewal fails and the cached token, which
+// expires at expiresAt, is served instead. It is called on the request path
+// and must return quickly.
+type StaleTokenHook func(installationID string, expiresAt time.Time, err error)
+
+// SetStaleWhileError makes the token manager serve a cached token whose
+// renewal failed for as long as the token has not actually expired. Further
+// renewals of the token are backed off, starting at 10s and doubling up to 2m
+// after each failure, and an error is only returned once the token expires.
+// Cached tokens are always served stale while the circuit breaker is open.
+func (tm *TokenManager) SetStaleWhileError(enabled bool) {
+	tm.staleWhileError = enabled
+}
+
+// SetStaleTokenHook sets a hook called each time a failed renewal is answered
+// with the cached token. A nil hook removes it.
+func (tm *TokenManager) SetStaleTokenHook(hook StaleTokenHook) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tm.staleHook = hook
+}
+
+// canServeStale reports whether a failed renewal of cached may be answered with the cached token
+func (tm *TokenManager) canServeStale(cached *cachedToken, err error) bool {
+	if tm.IsTokenExpired(cached.load().token, 0) {
+		return false
+	}
+	return tm.staleWhileError || errors.Is(err, ErrCircuitOpen)
+}
+
+// inRenewalBackoff reports whether renewals of cached are backed off after a failure
+func (tm *TokenManager) inRenewalBackoff(cached *cachedToken) bool {
+	state := cached.load()
+	return tm.now().Before(state.nextRenewal) && !tm.IsTokenExpired(state.token, 0)
+}
+
+// renewalBackoff returns how long to wait before renewing again after the given number of consecutive failures
+func renewalBackoff(failures int) time.Duration {
+	backoff := staleRenewalMinBackoff
+	for i := 1; i < failures && backoff < staleRenewalMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, staleRenewalMaxBackoff)
+}
+
+// serveStale returns the cached token of a renewal that failed with err, or
+// that is backed off after an earlier failure when err is nil. The caller
+// holds cached.renewMutex.
+func (tm *TokenManager) serveStale(ctx context.Context, cached *cachedToken, err error) *types.GitHubAppToken {
+	state := cached.load()
+	if err != nil {
+		state = cached.update(func(state *tokenState) {
+			state.renewFailures++
+			state.nextRenewal = tm.now().Add(renewalBackoff(state.renewFailures))
+		})
+
+		tm.log().WarnContext(ctx, "failed to renew installation token, serving cached token",
+			"installation_id", cached.installationID, "expires_at", state.token.ExpiresAt,
+			"next_renewal", state.nextRenewal, "error", err)
+
+		tm.mutex.RLock()
+		hook := tm.staleHook
+		tm.mutex.RUnlock()
+		if hook != nil {
+			hook(cached.installationID, state.token.ExpiresAt, err)
+		}
+	} else {
+		tm.log().DebugContext(ctx, "renewal backed off, serving cached installation token",
+			"installation_id", cached.installationID, "expires_at", state.token.ExpiresAt,
+			"next_renewal", state.nextRenewal)
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attrTokenCache.String("stale"))
+	tm.counters.stale.Add(1)
+	tm.auth.metrics.TokenServedStale()
+	tm.auth.emit(ctx, tokenEvent(audit.EventCacheHit, cached.installationID, cached.request, state.token))
+	return state.token
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ghappauth/internal/types"
+)
+
+func TestTokenManager_StaleWhileError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{
+			Token:     "ghs_stale",
+			ExpiresAt: time.Now().Add(2 * time.Minute),
+		})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+	auth.httpClient = NewHTTPClient(&HTTPClientConfig{MaxRetries: 1})
+
+	// The token is within the renew buffer as soon as it is minted
+	tm := NewTokenManager(auth, 5*time.Minute)
+	tm.SetStaleWhileError(true)
+
+	var hookErr error
+	tm.SetStaleTokenHook(func(installationID string, expiresAt time.Time, err error) {
+		if installationID != "67890" {
+			t.Errorf("Unexpected installation ID %q", installationID)
+		}
+		hookErr = err
+	})
+
+	if _, err := tm.GetToken(); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	token, err := tm.GetToken()
+	if err != nil {
+		t.Fatalf("Expected the cached token after a failed renewal, got %v", err)
+	}
+	if token.Token != "ghs_stale" {
+		t.Errorf("Expected the cached token, got %q", token.Token)
+	}
+	var retryErr *RetryableError
+	if !errors.As(hookErr, &retryErr) {
+		t.Errorf("Expected the hook to receive the renewal error, got %v", hookErr)
+	}
+
+	// Renewal is backed off after the failure
+	if _, err := tm.GetToken(); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected no renewal while backed off, got %d requests", requests)
+	}
+
+	stats := tm.GetCacheStats()
+	if stats.Stale != 2 || stats.Failures != 1 {
+		t.Errorf("Expected 2 stale tokens and 1 failure, got %+v", stats)
+	}
+	entry := stats.CacheDetails["67890"]
+	if remaining := time.Until(entry.NextRenewal); remaining <= 0 || remaining > staleRenewalMinBackoff {
+		t.Errorf("Expected the next renewal within %v, got %v", staleRenewalMinBackoff, entry.NextRenewal)
+	}
+
+	// Once the token has expired the renewal error is returned
+	expireCachedToken(t, tm, "67890")
+	if _, err := tm.GetToken(); err == nil {
+		t.Fatal("Expected an error once the token expired")
+	}
+	if requests != 3 {
+		t.Errorf("Expected a renewal of the expired token, got %d requests", requests)
+	}
+}
+
+func TestRenewalBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{5, 2 * time.Minute},
+		{100, 2 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := renewalBackoff(tt.failures); got != tt.want {
+			t.Errorf("renewalBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxCachedTokens is the bound on the number of tokens in a TokenCache
+// until SetMaxEntries changes it
+const DefaultMaxCachedTokens = 1000
+
+// TokenCache holds the installation tokens of a TokenManager by installation
+// and scope. Token managers of the same GitHubAppAuth can share a cache, so a
+// token minted by one is served by all of them.
+type TokenCache struct {
+	mutex      sync.RWMutex
+	entries    map[string]*list.Element // Values are *cachedToken
+	order      *list.List               // Most recently used first
+	maxEntries int                      // 0 means unbounded
+}
+
+// NewTokenCache creates an empty token cache holding at most
+// DefaultMaxCachedTokens tokens
+func NewTokenCache() *TokenCache {
+	return &TokenCache{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: DefaultMaxCachedTokens,
+	}
+}
+
+// Len returns the number of cached tokens
+func (c *TokenCache) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return len(c.entries)
+}
+
+// SetMaxEntries bounds the number of cached tokens. When a new token would
+// exceed the bound, the least recently used tokens are evicted. 0 removes the
+// bound.
+func (c *TokenCache) SetMaxEntries(maxEntries int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.maxEntries = max(maxEntries, 0)
+}
+
+// MaxEntries returns the bound on the number of cached tokens; 0 means unbounded
+func (c *TokenCache) MaxEntries() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.maxEntries
+}
+
+// get returns the token cached under key, if any, marking it used at now
+func (c *TokenCache) get(key string, now time.Time) (*cachedToken, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+
+	cached := element.Value.(*cachedToken)
+	cached.lastUsed = now
+	return cached, true
+}
+
+// set caches a token under its key, replacing any token cached before, and
+// returns the least recently used tokens evicted to stay within the bound
+func (c *TokenCache) set(cached *cachedToken) []*cachedToken {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, exists := c.entries[cached.key]; exists {
+		element.Value = cached
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[cached.key] = c.order.PushFront(cached)
+	}
+
+	var evicted []*cachedToken
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		evicted = append(evicted, c.remove(c.order.Back()))
+	}
+	return evicted
+}
+
+// remove drops an element from the cache and returns its token. The caller
+// holds the write lock.
+func (c *TokenCache) remove(element *list.Element) *cachedToken {
+	cached := element.Value.(*cachedToken)
+	c.order.Remove(element)
+	delete(c.entries, cached.key)
+	return cached
+}
+
+// removeExpired removes and returns the tokens for which expired reports
+// true. Tokens being renewed are skipped.
+func (c *TokenCache) removeExpired(expired func(*cachedToken) bool) []*cachedToken {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var removed []*cachedToken
+	for _, element := range c.entries {
+		cached := element.Value.(*cachedToken)
+		if !cached.renewMutex.TryLock() {
+			continue
+		}
+		isExpired := expired(cached)
+		cached.renewMutex.Unlock()
+
+		if isExpired {
+			removed = append(removed, c.remove(element))
+		}
+	}
+	return removed
+}
+
+// removeInstallation removes and returns the tokens of an installation, scoped or not
+func (c *TokenCache) removeInstallation(installationID string) []*cachedToken {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var removed []*cachedToken
+	for key, element := range c.entries {
+		if key == installationID || strings.HasPrefix(key, installationID+"?") {
+			removed = append(removed, c.remove(element))
+		}
+	}
+	return removed
+}
+
+// clear removes and returns all cached tokens
+func (c *TokenCache) clear() []*cachedToken {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	removed := make([]*cachedToken, 0, len(c.entries))
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		removed = append(removed, element.Value.(*cachedToken))
+	}
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	return removed
+}
+
+// SetMaxCachedTokens bounds the number of tokens in the cache of the token
+// manager, evicting the least recently used ones beyond it. The cache holds at
+// most DefaultMaxCachedTokens tokens until this is called; 0 removes the bound.
+func (tm *TokenManager) SetMaxCachedTokens(maxEntries int) {
+	tm.cache.SetMaxEntries(maxEntries)
+}
+
+// PruneExpired removes expired tokens from the cache and returns how many were removed
+func (tm *TokenManager) PruneExpired() int {
+	removed := tm.cache.removeExpired(func(cached *cachedToken) bool {
+		return tm.IsTokenExpired(cached.load().token, 0)
+	})
+
+	for _, cached := range removed {
+		tm.counters.expired.Add(1)
+		tm.auth.metrics.TokenCacheEviction("expired")
+		if !cached.load().expiryNotified {
+			tm.notify(TokenExpired, cached, nil)
+		}
+	}
+	if len(removed) > 0 {
+		tm.log().Debug("removed expired installation tokens from cache", "count", len(removed))
+	}
+
+	return len(removed)
+}
+
+// SweepCache calls PruneExpired every interval on a new goroutine, so tokens
+// that are no longer requested do not stay in the cache after they expire. It
+// stops when ctx is done. Without it, expired tokens are only dropped when
+// requested again or evicted by the bound.
+func (tm *TokenManager) SweepCache(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				tm.PruneExpired()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// evicted records tokens evicted to keep the cache within its bound
+func (tm *TokenManager) evicted(evicted []*cachedToken) {
+	for _, cached := range evicted {
+		tm.counters.evictions.Add(1)
+		tm.auth.metrics.TokenCacheEviction("capacity")
+		tm.log().Debug("evicted least recently used installation token from cache",
+			"installation_id", cached.installationID, "last_used", cached.lastUsed)
+		tm.notify(TokenInvalidated, cached, nil)
+	}
+}
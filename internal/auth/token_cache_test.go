@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ghappauth/internal/metrics"
+	"ghappauth/internal/types"
+)
+
+// newCacheTestManager returns a token manager for a server minting tokens that expire after lifetime
+func newCacheTestManager(t *testing.T, lifetime time.Duration, opts ...Option) *TokenManager {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{
+			Token:     "ghs_" + r.URL.Path,
+			ExpiresAt: time.Now().Add(lifetime),
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "1",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	return NewManager(auth, opts...)
+}
+
+// expireCachedToken makes the token cached under key expire a second ago
+func expireCachedToken(t *testing.T, tm *TokenManager, key string) {
+	t.Helper()
+	cached, ok := tm.cache.get(key, tm.now())
+	if !ok {
+		t.Fatalf("No token cached under %s", key)
+	}
+
+	cached.renewMutex.Lock()
+	defer cached.renewMutex.Unlock()
+	cached.update(func(state *tokenState) {
+		token := *state.token
+		token.ExpiresAt = time.Now().Add(-time.Second)
+		state.token = &token
+	})
+}
+
+func TestTokenCache_DefaultMaxEntries(t *testing.T) {
+	if got := NewTokenCache().MaxEntries(); got != DefaultMaxCachedTokens {
+		t.Errorf("MaxEntries() = %d, want %d", got, DefaultMaxCachedTokens)
+	}
+
+	tm := newCacheTestManager(t, time.Hour, WithMaxCachedTokens(0))
+	if got := tm.cache.MaxEntries(); got != 0 {
+		t.Errorf("MaxEntries() = %d, want 0 for an unbounded cache", got)
+	}
+}
+
+func TestTokenManager_MaxCachedTokens(t *testing.T) {
+	now := time.Now()
+	tm := newCacheTestManager(t, time.Hour,
+		WithMaxCachedTokens(2),
+		WithClock(func() time.Time { return now }))
+	registry := metrics.NewRegistry()
+	tm.auth.SetMetrics(registry)
+
+	get := func(installationID string) {
+		t.Helper()
+		now = now.Add(time.Second)
+		if _, err := tm.GetTokenForInstallation(installationID); err != nil {
+			t.Fatalf("GetTokenForInstallation(%s) error = %v", installationID, err)
+		}
+	}
+
+	get("1")
+	get("2")
+	get("1") // 2 is now the least recently used
+	get("3")
+
+	stats := tm.GetCacheStats()
+	if stats.TotalCached != 2 || stats.Evictions != 1 || stats.MaxEntries != 2 {
+		t.Errorf("Expected 2 cached tokens after 1 eviction, got %+v", stats)
+	}
+	if _, ok := stats.CacheDetails["2"]; ok {
+		t.Error("Expected the least recently used token to be evicted")
+	}
+	if _, ok := stats.CacheDetails["1"]; !ok {
+		t.Error("Expected the recently used token to stay cached")
+	}
+
+	var out strings.Builder
+	registry.WriteText(&out)
+	if !strings.Contains(out.String(), `ghappauth_token_cache_evictions_total{reason="capacity"} 1`) {
+		t.Errorf("Expected the eviction metric, got:\n%s", out.String())
+	}
+}
+
+func TestTokenManager_PruneExpired(t *testing.T) {
+	tm := newCacheTestManager(t, time.Hour)
+
+	for _, installationID := range []string{"1", "2"} {
+		if _, err := tm.GetTokenForInstallation(installationID); err != nil {
+			t.Fatalf("GetTokenForInstallation(%s) error = %v", installationID, err)
+		}
+	}
+	expireCachedToken(t, tm, "1")
+
+	expired := make(chan TokenEvent, 1)
+	tm.OnTokenExpired(func(event TokenEvent) { expired <- event })
+
+	if removed := tm.PruneExpired(); removed != 1 {
+		t.Errorf("Expected 1 expired token to be removed, got %d", removed)
+	}
+
+	stats := tm.GetCacheStats()
+	if stats.TotalCached != 1 || stats.Expired != 1 {
+		t.Errorf("Expected 1 cached token after 1 expiry, got %+v", stats)
+	}
+
+	select {
+	case event := <-expired:
+		if event.InstallationID != "1" {
+			t.Errorf("Expected installation 1 to expire, got %s", event.InstallationID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the expired event")
+	}
+}
+
+func TestTokenManager_SweepCache(t *testing.T) {
+	tm := newCacheTestManager(t, time.Hour)
+	if err := tm.SweepCache(t.Context(), 0); err == nil {
+		t.Error("Expected an error for a zero interval")
+	}
+
+	if _, err := tm.GetToken(); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	expireCachedToken(t, tm, "1")
+
+	if err := tm.SweepCache(t.Context(), 10*time.Millisecond); err != nil {
+		t.Fatalf("SweepCache() error = %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tm.cache.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the sweep")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Run with -race: renewals publish token state while statistics and pruning read it
+func TestTokenManager_ConcurrentRenewalAndStats(t *testing.T) {
+	// Tokens expire within the renew buffer, so every request renews
+	tm := newCacheTestManager(t, time.Minute, WithMaxCachedTokens(4))
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			installationID := strconv.Itoa(i % 6)
+			for range 20 {
+				if _, err := tm.GetTokenForInstallation(installationID); err != nil {
+					t.Errorf("GetTokenForInstallation(%s) error = %v", installationID, err)
+					return
+				}
+			}
+		}()
+	}
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				stats := tm.GetCacheStats()
+				if stats.TotalCached > 4 {
+					t.Errorf("Expected at most 4 cached tokens, got %d", stats.TotalCached)
+				}
+				tm.PruneExpired()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if stats := tm.GetCacheStats(); stats.Renewals == 0 {
+		t.Errorf("Expected renewals, got %+v", stats)
+	}
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"ghappauth/internal/audit"
+	"ghappauth/internal/types"
+)
+
+// TokenEventType identifies a change in the lifecycle of a cached token
+type TokenEventType string
+
+const (
+	// TokenIssued is delivered when a token is minted and cached
+	TokenIssued TokenEventType = "issued"
+	// TokenRenewed is delivered when a cached token is replaced by a new one
+	TokenRenewed TokenEventType = "renewed"
+	// TokenExpired is delivered once when a cached token is found expired
+	TokenExpired TokenEventType = "expired"
+	// TokenRenewalFailed is delivered when a cached token could not be renewed
+	TokenRenewalFailed TokenEventType = "renewal_failed"
+	// TokenInvalidated is delivered when a token is removed from the cache
+	TokenInvalidated TokenEventType = "invalidated"
+)
+
+// TokenEvent describes a token lifecycle change. It never carries the token
+// itself, only metadata that is safe to log.
+type TokenEvent struct {
+	Type           TokenEventType
+	InstallationID string
+	// Scope is the scope the token was requested with; nil for unscoped tokens
+	Scope *types.InstallationTokenRequest
+	// Fingerprint identifies the token, as in audit events
+	Fingerprint         string
+	ExpiresAt           time.Time
+	Permissions         map[string]string
+	RepositorySelection string
+	// Repositories are the full names of the repositories the token can access
+	Repositories []string
+	// Err is the renewal error of TokenRenewalFailed events
+	Err error
+}
+
+// OnTokenIssued subscribes fn to tokens minted and cached. It returns a function that unsubscribes fn.
+func (tm *TokenManager) OnTokenIssued(fn func(TokenEvent)) (unsubscribe func()) {
+	return tm.notifier.subscribe(TokenIssued, fn)
+}
+
+// OnTokenRenewed subscribes fn to cached tokens replaced by new ones. It returns a function that unsubscribes fn.
+func (tm *TokenManager) OnTokenRenewed(fn func(TokenEvent)) (unsubscribe func()) {
+	return tm.notifier.subscribe(TokenRenewed, fn)
+}
+
+// OnTokenExpired subscribes fn to cached tokens found expired. It returns a function that unsubscribes fn.
+func (tm *TokenManager) OnTokenExpired(fn func(TokenEvent)) (unsubscribe func()) {
+	return tm.notifier.subscribe(TokenExpired, fn)
+}
+
+// OnRenewalFailed subscribes fn to failed renewals, including those answered
+// with a stale token. It returns a function that unsubscribes fn.
+func (tm *TokenManager) OnRenewalFailed(fn func(TokenEvent)) (unsubscribe func()) {
+	return tm.notifier.subscribe(TokenRenewalFailed, fn)
+}
+
+// OnInvalidated subscribes fn to tokens removed from the cache. It returns a function that unsubscribes fn.
+func (tm *TokenManager) OnInvalidated(fn func(TokenEvent)) (unsubscribe func()) {
+	return tm.notifier.subscribe(TokenInvalidated, fn)
+}
+
+// notify delivers a lifecycle event about cached to the subscribers
+func (tm *TokenManager) notify(eventType TokenEventType, cached *cachedToken, err error) {
+	event := TokenEvent{
+		Type:           eventType,
+		InstallationID: cached.installationID,
+		Scope:          cached.request,
+		Err:            err,
+	}
+	if token := cached.load().token; token != nil {
+		event.Fingerprint = audit.Fingerprint(token.Token)
+		event.ExpiresAt = token.ExpiresAt
+		event.Permissions = maps.Clone(token.Permissions) // Subscribers must not share the cached token's map
+		event.RepositorySelection = token.RepositorySelection
+		for _, repo := range token.Repositories {
+			event.Repositories = append(event.Repositories, repo.FullName)
+		}
+	}
+
+	if !tm.notifier.notify(event, tm.log()) {
+		tm.counters.droppedEvents.Add(1)
+	}
+}
+
+// maxQueuedTokenEvents bounds the events waiting for slow subscribers
+const maxQueuedTokenEvents = 1024
+
+// tokenNotifier delivers token events to subscribers on a separate
+// goroutine, one event at a time and in order. At most maxQueuedTokenEvents
+// events wait for delivery; further events are dropped until the queue drains.
+// The zero value is ready to use.
+type tokenNotifier struct {
+	mutex       sync.Mutex
+	subscribers map[TokenEventType][]*tokenSubscriber
+	queue       []tokenDelivery
+	delivering  bool // A goroutine is draining the queue
+	dropping    bool // Events are being dropped; logged once until one is queued again
+}
+
+type tokenSubscriber struct {
+	fn func(TokenEvent)
+}
+
+// tokenDelivery is an event together with the subscribers it goes to
+type tokenDelivery struct {
+	event       TokenEvent
+	subscribers []*tokenSubscriber
+	logger      *slog.Logger
+}
+
+func (n *tokenNotifier) subscribe(eventType TokenEventType, fn func(TokenEvent)) func() {
+	if fn == nil {
+		return func() {}
+	}
+
+	subscriber := &tokenSubscriber{fn: fn}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.subscribers == nil {
+		n.subscribers = make(map[TokenEventType][]*tokenSubscriber)
+	}
+	n.subscribers[eventType] = append(n.subscribers[eventType], subscriber)
+
+	return func() {
+		n.mutex.Lock()
+		defer n.mutex.Unlock()
+
+		subscribers := n.subscribers[eventType]
+		for i, s := range subscribers {
+			if s == subscriber {
+				// Copy so that deliveries already queued keep their slice
+				n.subscribers[eventType] = append(subscribers[:i:i], subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// notify queues event for its subscribers without waiting for them. It
+// returns false when the event was dropped because the queue is full.
+func (n *tokenNotifier) notify(event TokenEvent, logger *slog.Logger) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	subscribers := n.subscribers[event.Type]
+	if len(subscribers) == 0 {
+		return true
+	}
+
+	if len(n.queue) >= maxQueuedTokenEvents {
+		if !n.dropping {
+			n.dropping = true
+			logger.Warn("token event subscribers are falling behind, dropping events",
+				"event", string(event.Type), "installation_id", event.InstallationID, "queued", len(n.queue))
+		}
+		return false
+	}
+	n.dropping = false
+
+	n.queue = append(n.queue, tokenDelivery{event: event, subscribers: subscribers, logger: logger})
+	if !n.delivering {
+		n.delivering = true
+		go n.deliver()
+	}
+	return true
+}
+
+// deliver calls the subscribers of queued events until the queue is empty
+func (n *tokenNotifier) deliver() {
+	for {
+		n.mutex.Lock()
+		if len(n.queue) == 0 {
+			n.delivering = false
+			n.mutex.Unlock()
+			return
+		}
+		delivery := n.queue[0]
+		n.queue[0] = tokenDelivery{}
+		n.queue = n.queue[1:]
+		n.mutex.Unlock()
+
+		for _, subscriber := range delivery.subscribers {
+			delivery.call(subscriber)
+		}
+	}
+}
+
+// call runs one subscriber, logging instead of crashing when it panics
+func (d tokenDelivery) call(subscriber *tokenSubscriber) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.logger.Error("token event subscriber panicked",
+				"event", string(d.event.Type), "installation_id", d.event.InstallationID, "panic", fmt.Sprint(r))
+		}
+	}()
+	subscriber.fn(d.event)
+}
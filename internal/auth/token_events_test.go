@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ghappauth/internal/audit"
+	"ghappauth/internal/types"
+)
+
+func TestTokenManager_LifecycleEvents(t *testing.T) {
+	requests := 0
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"suspended"}`))
+			return
+		}
+		requests++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{
+			Token:       fmt.Sprintf("ghs_token%d", requests),
+			ExpiresAt:   time.Now().Add(time.Hour),
+			Permissions: map[string]string{"contents": "read"},
+		})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	tm := NewTokenManager(auth, 5*time.Minute)
+	events := make(chan TokenEvent, 10)
+	record := func(event TokenEvent) { events <- event }
+	tm.OnTokenIssued(record)
+	tm.OnTokenRenewed(record)
+	tm.OnTokenExpired(record)
+	tm.OnRenewalFailed(record)
+	tm.OnInvalidated(record)
+
+	// A panicking subscriber does not stop the others
+	tm.OnTokenIssued(func(TokenEvent) { panic("boom") })
+
+	if _, err := tm.GetToken(); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	expireCachedToken(t, tm, "67890")
+	if _, err := tm.GetToken(); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	fail = true
+	tm.SetRenewBuffer(2 * time.Hour)
+	if _, err := tm.GetToken(); err == nil {
+		t.Fatal("Expected the renewal to fail")
+	}
+	tm.InvalidateToken()
+
+	want := []TokenEventType{TokenIssued, TokenExpired, TokenRenewed, TokenRenewalFailed, TokenInvalidated}
+	for i, wantType := range want {
+		var event TokenEvent
+		select {
+		case event = <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for event %d (%s)", i, wantType)
+		}
+
+		if event.Type != wantType || event.InstallationID != "67890" {
+			t.Errorf("Event %d: expected %s for 67890, got %+v", i, wantType, event)
+		}
+		// Events before the renewal describe the first token
+		wantToken := "ghs_token2"
+		if i < 2 {
+			wantToken = "ghs_token1"
+		}
+		if event.Fingerprint != audit.Fingerprint(wantToken) {
+			t.Errorf("Event %d: expected the fingerprint of %s, got %q", i, wantToken, event.Fingerprint)
+		}
+		if event.Permissions["contents"] != "read" || event.ExpiresAt.IsZero() {
+			t.Errorf("Event %d: expected token metadata, got %+v", i, event)
+		}
+		if strings.Contains(fmt.Sprintf("%+v", event), "ghs_token") {
+			t.Errorf("Event %d leaks the token: %+v", i, event)
+		}
+		if (event.Type == TokenRenewalFailed) != (event.Err != nil) {
+			t.Errorf("Event %d: unexpected error %v", i, event.Err)
+		}
+	}
+}
+
+func TestTokenManager_Unsubscribe(t *testing.T) {
+	var notifier tokenNotifier
+	delivered := make(chan string, 2)
+
+	unsubscribe := notifier.subscribe(TokenIssued, func(TokenEvent) { delivered <- "first" })
+	notifier.subscribe(TokenIssued, func(TokenEvent) { delivered <- "second" })
+	unsubscribe()
+
+	notifier.notify(TokenEvent{Type: TokenIssued}, discardLogger)
+	select {
+	case got := <-delivered:
+		if got != "second" {
+			t.Errorf("Expected only the remaining subscriber, got %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the event")
+	}
+
+	select {
+	case got := <-delivered:
+		t.Errorf("Unexpected delivery to %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestTokenNotifier_DropsWhenFull(t *testing.T) {
+	var notifier tokenNotifier
+	release := make(chan struct{})
+	delivered := make(chan struct{}, maxQueuedTokenEvents+1)
+	notifier.subscribe(TokenIssued, func(TokenEvent) {
+		<-release
+		delivered <- struct{}{}
+	})
+
+	// The first event is taken off the queue by the blocked subscriber
+	if !notifier.notify(TokenEvent{Type: TokenIssued}, discardLogger) {
+		t.Fatal("notify() dropped the first event")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		notifier.mutex.Lock()
+		queued := len(notifier.queue)
+		notifier.mutex.Unlock()
+		if queued == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the delivery to start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := range maxQueuedTokenEvents {
+		if !notifier.notify(TokenEvent{Type: TokenIssued}, discardLogger) {
+			t.Fatalf("notify() dropped event %d before the queue was full", i)
+		}
+	}
+	if notifier.notify(TokenEvent{Type: TokenIssued}, discardLogger) {
+		t.Error("notify() should drop events once the queue is full")
+	}
+
+	close(release)
+	for i := range maxQueuedTokenEvents + 1 {
+		select {
+		case <-delivered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for event %d", i)
+		}
+	}
+}
+
+func TestTokenManager_EventPermissionsAreCopied(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{
+			Token:       "ghs_token",
+			ExpiresAt:   time.Now().Add(time.Hour),
+			Permissions: map[string]string{"contents": "read"},
+		})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+	tm := NewTokenManager(auth, 5*time.Minute)
+
+	issued := make(chan struct{})
+	tm.OnTokenIssued(func(event TokenEvent) {
+		event.Permissions["contents"] = "write"
+		close(issued)
+	})
+
+	token, err := tm.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	select {
+	case <-issued:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the event")
+	}
+
+	if got := token.Permissions["contents"]; got != "read" {
+		t.Errorf("Subscriber changed the cached token's permissions to %q", got)
+	}
+}
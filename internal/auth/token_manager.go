@@ -1,144 +1,290 @@
 package auth
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
+
+	"ghappauth/internal/audit"
 	"ghappauth/internal/types"
 )
 
 // TokenManager handles caching and automatic renewal of installation tokens
 type TokenManager struct {
 	auth        *GitHubAppAuth
-	cache       map[string]*cachedToken
-	mutex       sync.RWMutex
-	renewBuffer time.Duration // How much time before expiry to renew the token
+	cache       *TokenCache
+	mutex       sync.RWMutex     // Guards the hooks
+	renewBuffer time.Duration    // How much time before expiry to renew the token
+	logger      *slog.Logger     // Falls back to the logger of auth when nil
+	clock       func() time.Time // Falls back to the clock of auth when nil
+	counters    cacheCounters
+	notifier    tokenNotifier
+
+	staleWhileError bool           // Serve cached tokens whose renewal failed until they expire
+	staleHook       StaleTokenHook // Guarded by mutex
 }
 
-// cachedToken represents a cached installation token
+// cachedToken represents a cached installation token. What changes when the
+// token is renewed is published as an immutable tokenState, so requests and
+// cache statistics read it without waiting for a renewal.
 type cachedToken struct {
-	token      *types.GitHubAppToken
-	createdAt  time.Time
-	lastUsed   time.Time
-	renewing   bool
-	renewMutex sync.Mutex
+	key            string
+	installationID string
+	request        *types.InstallationTokenRequest
+	lastUsed       time.Time // Guarded by the mutex of the cache
+	state          atomic.Pointer[tokenState]
+	renewMutex     sync.Mutex // Held while renewing; serializes updates of state
 }
 
-// NewTokenManager creates a new token manager
-func NewTokenManager(auth *GitHubAppAuth, renewBuffer time.Duration) *TokenManager {
-	if renewBuffer == 0 {
-		renewBuffer = 5 * time.Minute // Default 5 minutes buffer
-	}
+// tokenState is a snapshot of a cached token. It is replaced, never modified.
+type tokenState struct {
+	token          *types.GitHubAppToken
+	createdAt      time.Time
+	renewing       bool
+	renewCount     int
+	lastError      error     // Error of the last failed renewal
+	renewFailures  int       // Consecutive failed renewals
+	nextRenewal    time.Time // No renewal is attempted before this while the token is served stale
+	expiryNotified bool      // TokenExpired was delivered for the current token
+}
 
-	return &TokenManager{
-		auth:        auth,
-		cache:       make(map[string]*cachedToken),
-		renewBuffer: renewBuffer,
-	}
+// load returns the current state of the cached token
+func (c *cachedToken) load() *tokenState {
+	return c.state.Load()
+}
+
+// update publishes a copy of the current state changed by fn and returns it.
+// The caller holds renewMutex.
+func (c *cachedToken) update(fn func(state *tokenState)) *tokenState {
+	state := *c.state.Load()
+	fn(&state)
+	c.state.Store(&state)
+	return &state
+}
+
+// NewTokenManager creates a new token manager. It is equivalent to
+// NewManager(auth, WithRenewBuffer(renewBuffer)).
+func NewTokenManager(auth *GitHubAppAuth, renewBuffer time.Duration) *TokenManager {
+	return NewManager(auth, WithRenewBuffer(renewBuffer))
 }
 
 // GetToken retrieves a valid installation token, renewing if necessary
 func (tm *TokenManager) GetToken() (*types.GitHubAppToken, error) {
-	tm.mutex.RLock()
-	cached, exists := tm.cache[tm.auth.config.InstallationID]
-	tm.mutex.RUnlock()
+	return tm.GetTokenForInstallation(tm.auth.config.InstallationID)
+}
+
+// GetTokenForInstallation retrieves a valid token for any installation of the
+// App, renewing if necessary. Tokens are cached per installation.
+func (tm *TokenManager) GetTokenForInstallation(installationID string) (*types.GitHubAppToken, error) {
+	return tm.GetScopedToken(context.Background(), installationID, nil)
+}
+
+// GetScopedToken retrieves a valid token for an installation limited to the
+// repositories and permissions in request, renewing if necessary. Tokens are
+// cached per installation and scope; a nil request returns an unscoped token.
+// The token policy is checked on every call, even when the token is cached.
+func (tm *TokenManager) GetScopedToken(ctx context.Context, installationID string, request *types.InstallationTokenRequest) (_ *types.GitHubAppToken, err error) {
+	ctx, span := tm.auth.tracer.Start(ctx, "TokenManager.GetToken", trace.WithAttributes(
+		attrInstallationID.String(installationID),
+		attrTokenScoped.Bool(request != nil),
+	))
+	defer func() { endSpan(span, err) }()
 
-	if exists && cached != nil {
-		cached.lastUsed = time.Now()
+	if installationID == "" {
+		return nil, fmt.Errorf("installation_id is required")
+	}
 
-		if !tm.IsTokenExpired(cached.token, tm.renewBuffer) {
-			return cached.token, nil
+	if err := tm.auth.AuthorizeToken(ctx, installationID, request); err != nil {
+		return nil, err
+	}
+
+	key, err := cacheKey(installationID, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached, exists := tm.cache.get(key, tm.now()); exists {
+		if token := cached.load().token; !tm.IsTokenExpired(token, tm.renewBuffer) {
+			span.SetAttributes(attrTokenCache.String("hit"))
+			tm.counters.hits.Add(1)
+			tm.log().DebugContext(ctx, "using cached installation token", tokenLogAttrs(installationID, token)...)
+			tm.auth.metrics.TokenCacheHit()
+			tm.auth.emit(ctx, tokenEvent(audit.EventCacheHit, installationID, request, token))
+			return token, nil
 		}
 
-		return tm.renewToken(cached)
+		span.SetAttributes(attrTokenCache.String("renew"))
+		return tm.renewToken(ctx, cached)
 	}
 
-	return tm.createNewToken()
+	span.SetAttributes(attrTokenCache.String("miss"))
+	return tm.createNewToken(ctx, key, installationID, request)
+}
+
+// cacheKey identifies a token by installation and, for scoped tokens, a
+// canonical form of the requested scope
+func cacheKey(installationID string, request *types.InstallationTokenRequest) (string, error) {
+	if request == nil || (len(request.Repositories) == 0 && len(request.RepositoryIDs) == 0 && len(request.Permissions) == 0) {
+		return installationID, nil
+	}
+
+	canonical := types.InstallationTokenRequest{
+		Repositories:  append([]string(nil), request.Repositories...),
+		RepositoryIDs: append([]int(nil), request.RepositoryIDs...),
+		Permissions:   request.Permissions,
+	}
+	sort.Strings(canonical.Repositories)
+	sort.Ints(canonical.RepositoryIDs)
+
+	// Map keys are sorted by encoding/json, so equal scopes encode equally
+	scope, err := json.Marshal(canonical)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token scope: %w", err)
+	}
+
+	return installationID + "?" + string(scope), nil
 }
 
 // renewToken renews an existing cached token
-func (tm *TokenManager) renewToken(cached *cachedToken) (*types.GitHubAppToken, error) {
+func (tm *TokenManager) renewToken(ctx context.Context, cached *cachedToken) (*types.GitHubAppToken, error) {
 	cached.renewMutex.Lock()
 	defer cached.renewMutex.Unlock()
 
-	if !tm.IsTokenExpired(cached.token, tm.renewBuffer) {
-		return cached.token, nil
+	// Another caller renewed the token while we waited for the lock
+	state := cached.load()
+	if !tm.IsTokenExpired(state.token, tm.renewBuffer) {
+		tm.auth.metrics.TokenCacheHit()
+		tm.counters.hits.Add(1)
+		tm.auth.emit(ctx, tokenEvent(audit.EventCacheHit, cached.installationID, cached.request, state.token))
+		return state.token, nil
 	}
 
-	cached.renewing = true
+	if tm.IsTokenExpired(state.token, 0) && !state.expiryNotified {
+		cached.update(func(state *tokenState) { state.expiryNotified = true })
+		tm.notify(TokenExpired, cached, nil)
+	}
+
+	if tm.inRenewalBackoff(cached) {
+		return tm.serveStale(ctx, cached, nil), nil
+	}
 
-	newToken, err := tm.auth.GetInstallationToken()
+	cached.update(func(state *tokenState) { state.renewing = true })
+
+	newToken, err := tm.auth.createInstallationToken(ctx, cached.installationID, cached.request)
+	tm.auth.metrics.TokenRenewed(err)
 	if err != nil {
-		cached.renewing = false
+		state := cached.update(func(state *tokenState) {
+			state.renewing = false
+			state.lastError = err
+		})
+		tm.counters.failures.Add(1)
+		tm.notify(TokenRenewalFailed, cached, err)
+
+		// While GitHub is failing, keep serving the token until it actually expires
+		if tm.canServeStale(cached, err) {
+			return tm.serveStale(ctx, cached, err), nil
+		}
+
+		tm.log().ErrorContext(ctx, "failed to renew installation token",
+			"installation_id", cached.installationID, "expires_at", state.token.ExpiresAt, "error", err)
 		return nil, fmt.Errorf("failed to renew token: %w", err)
 	}
 
-	cached.token = newToken
-	cached.createdAt = time.Now()
-	cached.renewing = false
+	// A successful renewal clears the failure and backoff state
+	cached.update(func(state *tokenState) {
+		*state = tokenState{token: newToken, createdAt: tm.now(), renewCount: state.renewCount + 1}
+	})
+	tm.counters.renewals.Add(1)
 
+	tm.log().InfoContext(ctx, "renewed installation token", tokenLogAttrs(cached.installationID, newToken)...)
+	tm.auth.emit(ctx, tokenEvent(audit.EventRenew, cached.installationID, cached.request, newToken))
+	tm.notify(TokenRenewed, cached, nil)
 	return newToken, nil
 }
 
 // createNewToken creates a new token and caches it
-func (tm *TokenManager) createNewToken() (*types.GitHubAppToken, error) {
-	token, err := tm.auth.GetInstallationToken()
+func (tm *TokenManager) createNewToken(ctx context.Context, key string, installationID string, request *types.InstallationTokenRequest) (*types.GitHubAppToken, error) {
+	tm.auth.metrics.TokenCacheMiss()
+	tm.counters.misses.Add(1)
+
+	token, err := tm.auth.createInstallationToken(ctx, installationID, request)
+	tm.auth.metrics.TokenMinted(err)
 	if err != nil {
+		tm.counters.failures.Add(1)
 		return nil, fmt.Errorf("failed to create new token: %w", err)
 	}
 
-	tm.mutex.Lock()
-	tm.cache[tm.auth.config.InstallationID] = &cachedToken{
-		token:     token,
-		createdAt: time.Now(),
-		lastUsed:  time.Now(),
-		renewing:  false,
+	now := tm.now()
+	cached := &cachedToken{
+		key:            key,
+		installationID: installationID,
+		request:        request,
+		lastUsed:       now,
 	}
-	tm.mutex.Unlock()
+	cached.state.Store(&tokenState{token: token, createdAt: now})
+	tm.evicted(tm.cache.set(cached))
 
+	tm.log().InfoContext(ctx, "minted installation token", tokenLogAttrs(installationID, token)...)
+	tm.auth.emit(ctx, tokenEvent(audit.EventMint, installationID, request, token))
+	tm.notify(TokenIssued, cached, nil)
 	return token, nil
 }
 
 // InvalidateToken removes the token from cache, forcing renewal on next request
 func (tm *TokenManager) InvalidateToken() {
-	tm.mutex.Lock()
-	delete(tm.cache, tm.auth.config.InstallationID)
-	tm.mutex.Unlock()
+	tm.InvalidateInstallationToken(tm.auth.config.InstallationID)
+}
+
+// InvalidateInstallationToken removes the tokens of the given installation,
+// scoped or not, from cache
+func (tm *TokenManager) InvalidateInstallationToken(installationID string) {
+	tm.emitInvalidated(tm.cache.removeInstallation(installationID))
 }
 
 // ClearCache removes all cached tokens
 func (tm *TokenManager) ClearCache() {
-	tm.mutex.Lock()
-	tm.cache = make(map[string]*cachedToken)
-	tm.mutex.Unlock()
-}
-
-// GetCacheStats returns statistics about the token cache
-func (tm *TokenManager) GetCacheStats() map[string]interface{} {
-	tm.mutex.RLock()
-	defer tm.mutex.RUnlock()
-
-	stats := map[string]interface{}{
-		"total_cached": len(tm.cache),
-		"renew_buffer": tm.renewBuffer.String(),
-	}
-
-	cacheDetails := make(map[string]interface{})
-	for installationID, cached := range tm.cache {
-		if cached != nil {
-			cacheDetails[installationID] = map[string]interface{}{
-				"created_at": cached.createdAt,
-				"last_used":  cached.lastUsed,
-				"renewing":   cached.renewing,
-				"expires_at": cached.token.ExpiresAt,
-				"is_expired": tm.IsTokenExpired(cached.token, 0),
-			}
-		}
+	tm.emitInvalidated(tm.cache.clear())
+}
+
+// emitInvalidated records tokens dropped from the cache
+func (tm *TokenManager) emitInvalidated(removed []*cachedToken) {
+	for _, cached := range removed {
+		tm.auth.emit(context.Background(), tokenEvent(audit.EventInvalidate, cached.installationID, cached.request, cached.load().token))
+		tm.notify(TokenInvalidated, cached, nil)
+	}
+}
+
+// SetLogger sets the logger for token mint and renewal events, overriding the
+// logger of the GitHubAppAuth. Credentials are redacted from everything logged.
+func (tm *TokenManager) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		tm.logger = discardLogger
+		return
+	}
+	tm.logger = slog.New(NewRedactingHandler(logger.Handler()))
+}
+
+// log returns the logger of the token manager, or of its GitHubAppAuth when none is set
+func (tm *TokenManager) log() *slog.Logger {
+	if tm.logger != nil {
+		return tm.logger
 	}
-	stats["cache_details"] = cacheDetails
+	return tm.auth.logger
+}
 
-	return stats
+// now returns the current time of the token manager's clock
+func (tm *TokenManager) now() time.Time {
+	if tm.clock != nil {
+		return tm.clock()
+	}
+	return tm.auth.clock()
 }
 
 // SetRenewBuffer sets the renewal buffer duration
@@ -157,5 +303,5 @@ func (tm *TokenManager) IsTokenExpired(token *types.GitHubAppToken, buffer time.
 		return true
 	}
 	
-	return time.Now().Add(buffer).After(token.ExpiresAt)
+	return tm.now().Add(buffer).After(token.ExpiresAt)
 } 
\ No newline at end of file
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+
+	"ghappauth/internal/audit"
+	"ghappauth/internal/policy"
+	"ghappauth/internal/types"
+)
+
+// TokenPolicy decides whether an installation token may be created. Authorize
+// returns nil to allow the request, or an error explaining the denial.
+type TokenPolicy interface {
+	Authorize(ctx context.Context, installationID string, request *types.InstallationTokenRequest) error
+}
+
+// decider is a TokenPolicy that explains its decisions, such as *policy.Policy
+type decider interface {
+	Decide(ctx context.Context, installationID string, request *types.InstallationTokenRequest) policy.Decision
+}
+
+// SetTokenPolicy sets the policy checked before installation tokens are
+// created, including tokens served from the TokenManager cache. A nil policy
+// allows every request.
+func (g *GitHubAppAuth) SetTokenPolicy(tokenPolicy TokenPolicy) {
+	g.hooksMutex.Lock()
+	defer g.hooksMutex.Unlock()
+
+	g.tokenPolicy = tokenPolicy
+}
+
+// AuthorizeToken checks a token request against the token policy without
+// contacting GitHub. Each decision is sent to the audit sink as an allow or
+// deny event.
+func (g *GitHubAppAuth) AuthorizeToken(ctx context.Context, installationID string, request *types.InstallationTokenRequest) error {
+	g.hooksMutex.RLock()
+	tokenPolicy := g.tokenPolicy
+	g.hooksMutex.RUnlock()
+
+	if tokenPolicy == nil {
+		return nil
+	}
+
+	if d, ok := tokenPolicy.(decider); ok {
+		decision := d.Decide(ctx, installationID, request)
+		g.emit(ctx, audit.DecisionEvent(decision))
+		if !decision.Allowed {
+			return &policy.DeniedError{Decision: decision}
+		}
+		return nil
+	}
+
+	err := tokenPolicy.Authorize(ctx, installationID, request)
+	event := tokenEvent(audit.EventAllow, installationID, request, nil)
+	if err != nil {
+		event.Type = audit.EventDeny
+		event.Reason = err.Error()
+	}
+	g.emit(ctx, event)
+	return err
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName identifies the spans of this package
+const tracerName = "ghappauth/internal/auth"
+
+// Span attributes specific to this package
+const (
+	attrAppID          = attribute.Key("ghappauth.app_id")
+	attrInstallationID = attribute.Key("ghappauth.installation_id")
+	attrTokenScoped    = attribute.Key("ghappauth.token.scoped")
+	attrTokenCache     = attribute.Key("ghappauth.token.cache")
+	attrRequestID      = attribute.Key("github.request_id")
+)
+
+// noopTracer is used until a tracer provider is set, so no spans are recorded by default
+var noopTracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// SetTracerProvider sets the OpenTelemetry provider used to trace JWT
+// signing, token lookups and the requests made to GitHub. Pass
+// otel.GetTracerProvider() to use the global provider; nil disables tracing.
+func (g *GitHubAppAuth) SetTracerProvider(provider trace.TracerProvider) {
+	g.httpClient.SetTracerProvider(provider)
+	g.tracer = g.httpClient.tracer
+}
+
+// SetTracerProvider sets the OpenTelemetry provider used to trace each
+// request attempt. A nil provider disables tracing.
+func (c *HTTPClient) SetTracerProvider(provider trace.TracerProvider) {
+	if provider == nil {
+		c.tracer = noopTracer
+		return
+	}
+	c.tracer = provider.Tracer(tracerName)
+}
+
+// startAttemptSpan starts the client span of one attempt of a request
+func (c *HTTPClient) startAttemptSpan(ctx context.Context, config *RequestConfig, endpoint string, attempt int) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(config.Method),
+		semconv.URLFull(config.URL),
+		semconv.URLTemplate(endpoint),
+	}
+	if attempt > 1 {
+		attrs = append(attrs, semconv.HTTPRequestResendCount(attempt-1))
+	}
+
+	return c.tracer.Start(ctx, config.Method+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+}
+
+// traceResponse records the status and GitHub request ID of a response on the span in ctx
+func traceResponse(ctx context.Context, response *http.Response) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(semconv.HTTPResponseStatusCode(response.StatusCode))
+	if requestID := response.Header.Get("X-GitHub-Request-Id"); requestID != "" {
+		span.SetAttributes(attrRequestID.String(requestID))
+	}
+	if response.StatusCode >= 400 {
+		span.SetStatus(codes.Error, http.StatusText(response.StatusCode))
+	}
+}
+
+// endSpan records err, if any, and ends span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
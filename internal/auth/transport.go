@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// newTransport returns the transport described by config, or nil to use http.DefaultTransport
+func newTransport(config *HTTPClientConfig) http.RoundTripper {
+	if config.Transport != nil {
+		return config.Transport
+	}
+	if config.ProxyURL == nil && config.RootCAs == nil {
+		return nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.ProxyURL != nil {
+		transport.Proxy = http.ProxyURL(config.ProxyURL)
+	}
+	if config.RootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    config.RootCAs,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return transport
+}
+
+// SetHTTPClient replaces the client used for GitHub API requests. The client
+// takes over the logger, metrics and tracer of the GitHubAppAuth. A nil
+// client restores the default one.
+func (g *GitHubAppAuth) SetHTTPClient(client *HTTPClient) {
+	if client == nil {
+		client = NewHTTPClient(nil)
+	}
+	client.logger = g.logger
+	client.metrics = g.metrics
+	client.tracer = g.tracer
+	g.httpClient = client
+}
+
+// SetStandardClient makes GitHub API requests go through client, keeping the
+// retry, circuit breaker and observability settings. Use it to share an
+// instrumented or mTLS-configured http.Client.
+func (g *GitHubAppAuth) SetStandardClient(client *http.Client) {
+	g.httpClient.SetStandardClient(client)
+}
+
+// SetTransport makes GitHub API requests go through transport, keeping the
+// retry, circuit breaker and observability settings
+func (g *GitHubAppAuth) SetTransport(transport http.RoundTripper) {
+	g.httpClient.SetTransport(transport)
+}
+
+// SetStandardClient sends requests with client instead of the http.Client
+// built from the configuration. A nil client restores the built one.
+func (c *HTTPClient) SetStandardClient(client *http.Client) {
+	if client == nil {
+		client = &http.Client{
+			Timeout:   c.config.Timeout,
+			Transport: newTransport(c.config),
+		}
+	}
+	c.client = client
+}
+
+// SetTransport sends requests through transport, ignoring the ProxyURL and
+// RootCAs of the configuration. A nil transport restores the configured one.
+func (c *HTTPClient) SetTransport(transport http.RoundTripper) {
+	if transport == nil {
+		transport = newTransport(c.config)
+	}
+
+	// Copy the client, which may be shared with other code
+	client := *c.client
+	client.Transport = transport
+	c.client = &client
+}
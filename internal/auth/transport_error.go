@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Kinds of transport errors that retrying may fix
+const (
+	TransportTimeout           = "timeout"
+	TransportConnectionReset   = "connection_reset"
+	TransportConnectionRefused = "connection_refused"
+	TransportDNS               = "dns"
+	TransportEOF               = "eof"
+)
+
+// TransportError is returned when a request to GitHub failed before a
+// response was received. It wraps the error of the transport, so
+// errors.Is(err, syscall.ECONNRESET) and similar checks keep working.
+type TransportError struct {
+	// Kind classifies a transient failure as one of the Transport* kinds. It
+	// is empty when the failure is not known to be transient, or when the
+	// context of the request was done.
+	Kind string
+	Err  error
+}
+
+func (e *TransportError) Error() string {
+	return "failed to make request: " + e.Err.Error()
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports whether the request may succeed if it is sent again
+func (e *TransportError) Transient() bool {
+	return e.Kind != ""
+}
+
+// transportErrorKind classifies err as one of the Transport* kinds, or "" when
+// it is not known to be transient
+func transportErrorKind(err error) string {
+	var transportErr *TransportError
+	if errors.As(err, &transportErr) {
+		return transportErr.Kind
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE):
+		return TransportConnectionReset
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return TransportConnectionRefused
+	}
+
+	// A lookup error for a name that does not exist is not worth retrying
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout || dnsErr.IsTemporary {
+			return TransportDNS
+		}
+		return ""
+	}
+
+	// Includes dial, TLS handshake and client timeouts
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return TransportTimeout
+	}
+
+	// The server closed a kept-alive connection as it was reused
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return TransportEOF
+	}
+
+	return ""
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTransportErrorKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "reset", err: &url.Error{Op: "Post", URL: "https://api.github.com", Err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, want: TransportConnectionReset},
+		{name: "broken pipe", err: &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, want: TransportConnectionReset},
+		{name: "refused", err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, want: TransportConnectionRefused},
+		{name: "temporary DNS failure", err: &net.DNSError{Err: "server misbehaving", Name: "api.github.com", IsTemporary: true}, want: TransportDNS},
+		{name: "unknown host", err: &net.DNSError{Err: "no such host", Name: "api.github.invalid", IsNotFound: true}, want: ""},
+		{name: "timeout", err: &url.Error{Op: "Get", URL: "https://api.github.com", Err: tlsHandshakeTimeout{}}, want: TransportTimeout},
+		{name: "EOF on reused connection", err: &url.Error{Op: "Get", URL: "https://api.github.com", Err: io.EOF}, want: TransportEOF},
+		{name: "unexpected EOF", err: fmt.Errorf("reading response: %w", io.ErrUnexpectedEOF), want: TransportEOF},
+		{name: "already classified", err: &TransportError{Kind: TransportDNS, Err: errors.New("lookup failed")}, want: TransportDNS},
+		{name: "other", err: errors.New("unsupported protocol scheme"), want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transportErrorKind(tt.err); got != tt.want {
+				t.Errorf("transportErrorKind() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPClient_doRequest_TransportErrorCause(t *testing.T) {
+	// Nothing listens on the address once the listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client := NewHTTPClient(&HTTPClientConfig{MaxRetries: 3, RetryDelay: time.Millisecond})
+
+	_, err = client.doRequest(context.Background(), &RequestConfig{Method: "GET", URL: "http://" + addr})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Expected the error to match ECONNREFUSED, got %v", err)
+	}
+
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) || transportErr.Kind != TransportConnectionRefused {
+		t.Errorf("Expected a connection_refused TransportError, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "request failed after 3 attempts") {
+		t.Errorf("Expected 3 attempts, got %v", err)
+	}
+}
+
+func TestHTTPClient_doRequest_CanceledNotRetried(t *testing.T) {
+	client := NewHTTPClient(&HTTPClientConfig{MaxRetries: 3, RetryDelay: time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.doRequest(ctx, &RequestConfig{Method: "GET", URL: "http://127.0.0.1:1"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
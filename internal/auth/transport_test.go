@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"ghappauth/internal/metrics"
+	"ghappauth/internal/types"
+)
+
+func TestHTTPClient_ProxyURL(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{}`))
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := NewHTTPClient(&HTTPClientConfig{MaxRetries: 1, ProxyURL: proxyURL})
+
+	err := client.DoRequest(t.Context(), &RequestConfig{
+		Method:         http.MethodGet,
+		URL:            "http://github.example.internal/api/v3/meta",
+		ExpectedStatus: http.StatusOK,
+	}, nil)
+	if err != nil {
+		t.Fatalf("DoRequest() error = %v", err)
+	}
+	if proxied != "http://github.example.internal/api/v3/meta" {
+		t.Errorf("Expected the request to go through the proxy, got %q", proxied)
+	}
+}
+
+func TestHTTPClient_RootCAs(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	request := &RequestConfig{Method: http.MethodGet, URL: server.URL, ExpectedStatus: http.StatusOK}
+
+	// The test server certificate is not trusted by the system pool
+	if err := NewHTTPClient(&HTTPClientConfig{MaxRetries: 1}).DoRequest(t.Context(), request, nil); err == nil {
+		t.Fatal("Expected a certificate error without RootCAs")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	client := NewHTTPClient(&HTTPClientConfig{MaxRetries: 1, RootCAs: pool})
+	if err := client.DoRequest(t.Context(), request, nil); err != nil {
+		t.Fatalf("DoRequest() error = %v", err)
+	}
+}
+
+// countingTransport counts the requests it sends
+type countingTransport struct {
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestGitHubAppAuth_InjectClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.InstallationTokenResponse{Token: "ghs_injected"})
+	}))
+	defer server.Close()
+
+	auth, err := NewGitHubAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     testPrivateKey,
+		InstallationID: "67890",
+		BaseURL:        server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+	registry := metrics.NewRegistry()
+	auth.SetMetrics(registry)
+
+	// The injected client takes over the metrics of the auth
+	client := NewHTTPClient(&HTTPClientConfig{MaxRetries: 1})
+	auth.SetHTTPClient(client)
+	if client.metrics != metrics.Recorder(registry) {
+		t.Error("Expected the injected client to use the metrics of the auth")
+	}
+
+	transport := &countingTransport{}
+	auth.SetStandardClient(&http.Client{Transport: transport})
+	if _, err := auth.CreateInstallationToken(t.Context(), "67890", nil); err != nil {
+		t.Fatalf("CreateInstallationToken() error = %v", err)
+	}
+	if transport.requests != 1 {
+		t.Errorf("Expected 1 request through the standard client, got %d", transport.requests)
+	}
+
+	other := &countingTransport{}
+	auth.SetTransport(other)
+	if _, err := auth.CreateInstallationToken(t.Context(), "67890", nil); err != nil {
+		t.Fatalf("CreateInstallationToken() error = %v", err)
+	}
+	if other.requests != 1 || transport.requests != 1 {
+		t.Errorf("Expected the request through the new transport, got %d and %d", other.requests, transport.requests)
+	}
+}
@@ -0,0 +1,347 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"ghappauth/internal/auth"
+	"ghappauth/internal/types"
+)
+
+// DefaultAppName is the name given to an App configured at the top level of a
+// config file or only through environment variables
+const DefaultAppName = "default"
+
+// Environment variables read by the loader
+const (
+	EnvConfigFile           = "GITHUB_APP_CONFIG"
+	EnvAppName              = "GITHUB_APP_NAME"
+	EnvAppID                = "GITHUB_APP_ID"
+	EnvPrivateKey           = "GITHUB_APP_PRIVATE_KEY"
+	EnvPrivateKeyPassphrase = "GITHUB_APP_PRIVATE_KEY_PASSPHRASE"
+	EnvFallbackPrivateKeys  = "GITHUB_APP_FALLBACK_PRIVATE_KEYS"
+	EnvInstallationID       = "GITHUB_APP_INSTALLATION_ID"
+	EnvBaseURL              = "GITHUB_APP_BASE_URL"
+	EnvDeployment           = "GITHUB_APP_DEPLOYMENT"
+)
+
+// legacyEnv maps environment variables to the older names used by the example,
+// which are still read when the new name is not set
+var legacyEnv = map[string]string{
+	EnvPrivateKey:     "GITHUB_PRIVATE_KEY",
+	EnvInstallationID: "GITHUB_INSTALLATION_ID",
+}
+
+// File is the on-disk configuration format. A file either configures a single
+// App at the top level or several named Apps under "apps".
+type File struct {
+	types.GitHubAppConfig `yaml:",inline"`
+
+	// Default names the App used when none is selected explicitly
+	Default string                            `json:"default,omitempty" yaml:"default,omitempty"`
+	Apps    map[string]*types.GitHubAppConfig `json:"apps,omitempty" yaml:"apps,omitempty"`
+}
+
+// ValidationError reports every problem found in a configuration
+type ValidationError = auth.ValidationError
+
+// Loader loads GitHub App configuration from a file and the environment.
+//
+// Values are applied in order of increasing precedence:
+//  1. the config file
+//  2. GITHUB_APP_* environment variables
+//  3. non-empty fields of Overrides, typically set from command-line flags
+//
+// Environment variables only apply to the selected App.
+type Loader struct {
+	// Path is the config file to read. Defaults to $GITHUB_APP_CONFIG; when
+	// neither is set the configuration comes from the environment only.
+	Path string
+	// App selects a named App from the file. Defaults to $GITHUB_APP_NAME,
+	// then the file's default, then the only App in the file.
+	App string
+	// LookupEnv reads environment variables. Defaults to os.LookupEnv.
+	LookupEnv func(key string) (string, bool)
+	// Overrides takes precedence over the file and the environment
+	Overrides *types.GitHubAppConfig
+	// InstallationOptional skips the installation_id requirement, for
+	// configurations only used with App-level endpoints
+	InstallationOptional bool
+}
+
+// Load loads and validates the selected App configuration using a Loader
+// configured entirely from the environment
+func Load() (*types.GitHubAppConfig, error) {
+	return (&Loader{}).Load()
+}
+
+// Load loads and validates the selected App configuration
+func (l *Loader) Load() (*types.GitHubAppConfig, error) {
+	apps, defaultApp, err := l.readApps()
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := l.selectApp(apps, defaultApp)
+	if err != nil {
+		return nil, err
+	}
+
+	config := apps[name]
+	if config == nil {
+		config = &types.GitHubAppConfig{}
+	}
+	l.applyEnv(config)
+	l.applyOverrides(config)
+
+	validate := Validate
+	if l.InstallationOptional {
+		validate = auth.ValidateAppConfig
+	}
+
+	if errs := validate(config); len(errs) > 0 {
+		return nil, &ValidationError{Errors: prefixErrors(name, errs, len(apps) > 1)}
+	}
+
+	return config, nil
+}
+
+// LoadAll loads and validates every App in the config file. Environment
+// variables are not applied.
+func (l *Loader) LoadAll() (map[string]*types.GitHubAppConfig, error) {
+	apps, _, err := l.readApps()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(apps) == 0 {
+		return nil, fmt.Errorf("no apps configured")
+	}
+
+	names := make([]string, 0, len(apps))
+	for name := range apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if apps[name] == nil {
+			errs = append(errs, fmt.Errorf("app %q: configuration is empty", name))
+			continue
+		}
+		errs = append(errs, prefixErrors(name, Validate(apps[name]), true)...)
+	}
+
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
+	}
+
+	return apps, nil
+}
+
+// readApps reads the configured Apps from the config file, if any
+func (l *Loader) readApps() (map[string]*types.GitHubAppConfig, string, error) {
+	path := l.Path
+	if path == "" {
+		path, _ = l.lookupEnv(EnvConfigFile)
+	}
+	if path == "" {
+		return map[string]*types.GitHubAppConfig{}, "", nil
+	}
+
+	file, err := LoadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	apps, err := file.apps()
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return apps, file.Default, nil
+}
+
+// selectApp picks the App to load
+func (l *Loader) selectApp(apps map[string]*types.GitHubAppConfig, defaultApp string) (string, error) {
+	name := l.App
+	if name == "" {
+		name, _ = l.lookupEnv(EnvAppName)
+	}
+	if name == "" {
+		name = defaultApp
+	}
+
+	if name != "" {
+		if _, ok := apps[name]; !ok && len(apps) > 0 {
+			return "", fmt.Errorf("app %q is not configured", name)
+		}
+		return name, nil
+	}
+
+	switch len(apps) {
+	case 0:
+		return DefaultAppName, nil
+	case 1:
+		for name := range apps {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("%d apps are configured, select one with %s or a default", len(apps), EnvAppName)
+}
+
+// applyEnv overrides config fields with any GITHUB_APP_* variables that are set
+func (l *Loader) applyEnv(config *types.GitHubAppConfig) {
+	fields := []struct {
+		env   string
+		field *string
+	}{
+		{EnvAppID, &config.AppID},
+		{EnvPrivateKey, &config.PrivateKey},
+		{EnvPrivateKeyPassphrase, &config.PrivateKeyPassphrase},
+		{EnvInstallationID, &config.InstallationID},
+		{EnvBaseURL, &config.BaseURL},
+		{EnvDeployment, &config.Deployment},
+	}
+
+	for _, f := range fields {
+		if value, ok := l.lookupEnv(f.env); ok && value != "" {
+			*f.field = value
+		} else if legacy, ok := legacyEnv[f.env]; ok {
+			if value, ok := l.lookupEnv(legacy); ok && value != "" && *f.field == "" {
+				*f.field = value
+			}
+		}
+	}
+
+	if value, ok := l.lookupEnv(EnvFallbackPrivateKeys); ok && value != "" {
+		config.FallbackPrivateKeys = nil
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				config.FallbackPrivateKeys = append(config.FallbackPrivateKeys, key)
+			}
+		}
+	}
+}
+
+// applyOverrides copies the non-empty fields of Overrides into config
+func (l *Loader) applyOverrides(config *types.GitHubAppConfig) {
+	if l.Overrides == nil {
+		return
+	}
+
+	overrides := []struct {
+		value string
+		field *string
+	}{
+		{l.Overrides.AppID, &config.AppID},
+		{l.Overrides.PrivateKey, &config.PrivateKey},
+		{l.Overrides.PrivateKeyPassphrase, &config.PrivateKeyPassphrase},
+		{l.Overrides.InstallationID, &config.InstallationID},
+		{l.Overrides.BaseURL, &config.BaseURL},
+		{l.Overrides.Deployment, &config.Deployment},
+	}
+
+	for _, o := range overrides {
+		if o.value != "" {
+			*o.field = o.value
+		}
+	}
+
+	if len(l.Overrides.FallbackPrivateKeys) > 0 {
+		config.FallbackPrivateKeys = l.Overrides.FallbackPrivateKeys
+	}
+}
+
+func (l *Loader) lookupEnv(key string) (string, bool) {
+	if l.LookupEnv != nil {
+		return l.LookupEnv(key)
+	}
+	return os.LookupEnv(key)
+}
+
+// LoadFile reads a YAML or JSON config file. Files ending in .json are parsed
+// as JSON; anything else is parsed as YAML. Unknown keys are rejected.
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file File
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return &file, nil
+}
+
+// apps returns the named Apps in the file, treating top-level settings as an
+// App named DefaultAppName
+func (f *File) apps() (map[string]*types.GitHubAppConfig, error) {
+	topLevel := f.GitHubAppConfig
+	hasTopLevel := topLevel.AppID != "" || topLevel.PrivateKey != "" || topLevel.InstallationID != "" ||
+		topLevel.BaseURL != "" || topLevel.Deployment != "" || topLevel.PrivateKeyPassphrase != "" || len(topLevel.FallbackPrivateKeys) > 0
+
+	if hasTopLevel && len(f.Apps) > 0 {
+		return nil, fmt.Errorf("top-level app settings cannot be combined with apps")
+	}
+
+	if hasTopLevel {
+		return map[string]*types.GitHubAppConfig{DefaultAppName: &topLevel}, nil
+	}
+
+	apps := make(map[string]*types.GitHubAppConfig, len(f.Apps))
+	for name, app := range f.Apps {
+		apps[name] = app
+	}
+
+	if f.Default != "" {
+		if _, ok := apps[f.Default]; !ok {
+			return nil, fmt.Errorf("default app %q is not configured", f.Default)
+		}
+	}
+
+	return apps, nil
+}
+
+// Validate checks everything NewGitHubAppAuth checks, including the shape of
+// BaseURL and Deployment, returning every problem found
+func Validate(config *types.GitHubAppConfig) []error {
+	return auth.ValidateConfig(config)
+}
+
+// prefixErrors adds the App name to each error when several Apps are configured
+func prefixErrors(name string, errs []error, named bool) []error {
+	if !named {
+		return errs
+	}
+
+	prefixed := make([]error, len(errs))
+	for i, err := range errs {
+		prefixed[i] = fmt.Errorf("app %q: %w", name, err)
+	}
+	return prefixed
+}
@@ -0,0 +1,330 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ghappauth/internal/types"
+)
+
+// writeTestKey writes a fresh RSA key to dir and returns a file:// reference to it
+func writeTestKey(t *testing.T, dir string) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	path := filepath.Join(dir, "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	return "file://" + path
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// envMap returns a LookupEnv function backed by a map
+func envMap(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestLoader_Load(t *testing.T) {
+	dir := t.TempDir()
+	keyRef := writeTestKey(t, dir)
+
+	single := writeFile(t, dir, "single.yaml", `
+app_id: "123"
+private_key: `+keyRef+`
+installation_id: "456"
+`)
+
+	multi := writeFile(t, dir, "multi.yaml", `
+default: prod
+apps:
+  prod:
+    app_id: "1"
+    private_key: `+keyRef+`
+    installation_id: "10"
+  ghes:
+    app_id: "2"
+    private_key: `+keyRef+`
+    installation_id: "20"
+    base_url: https://github.example.com/api/v3
+`)
+
+	jsonFile := writeFile(t, dir, "apps.json", `{
+  "apps": {
+    "only": {"app_id": "7", "private_key": "`+keyRef+`", "installation_id": "70"}
+  }
+}`)
+
+	tests := []struct {
+		name               string
+		loader             Loader
+		wantAppID          string
+		wantInstallationID string
+		wantBaseURL        string
+	}{
+		{
+			name:               "single app file",
+			loader:             Loader{Path: single},
+			wantAppID:          "123",
+			wantInstallationID: "456",
+		},
+		{
+			name:               "file default app",
+			loader:             Loader{Path: multi},
+			wantAppID:          "1",
+			wantInstallationID: "10",
+		},
+		{
+			name:               "explicit app",
+			loader:             Loader{Path: multi, App: "ghes"},
+			wantAppID:          "2",
+			wantInstallationID: "20",
+			wantBaseURL:        "https://github.example.com/api/v3",
+		},
+		{
+			name:               "app from environment",
+			loader:             Loader{Path: multi, LookupEnv: envMap(map[string]string{EnvAppName: "ghes"})},
+			wantAppID:          "2",
+			wantInstallationID: "20",
+			wantBaseURL:        "https://github.example.com/api/v3",
+		},
+		{
+			name:               "only app in JSON file",
+			loader:             Loader{Path: jsonFile},
+			wantAppID:          "7",
+			wantInstallationID: "70",
+		},
+		{
+			name: "environment overrides file",
+			loader: Loader{Path: single, LookupEnv: envMap(map[string]string{
+				EnvInstallationID: "999",
+				EnvBaseURL:        "https://ghe.example.com/api/v3",
+			})},
+			wantAppID:          "123",
+			wantInstallationID: "999",
+			wantBaseURL:        "https://ghe.example.com/api/v3",
+		},
+		{
+			name: "environment only",
+			loader: Loader{LookupEnv: envMap(map[string]string{
+				EnvAppID:          "5",
+				EnvPrivateKey:     keyRef,
+				EnvInstallationID: "50",
+			})},
+			wantAppID:          "5",
+			wantInstallationID: "50",
+		},
+		{
+			name: "config path from environment with legacy variables",
+			loader: Loader{LookupEnv: envMap(map[string]string{
+				EnvConfigFile:            single,
+				"GITHUB_INSTALLATION_ID": "ignored-because-file-sets-it",
+			})},
+			wantAppID:          "123",
+			wantInstallationID: "456",
+		},
+		{
+			name: "overrides take precedence over environment",
+			loader: Loader{
+				Path:      single,
+				LookupEnv: envMap(map[string]string{EnvInstallationID: "999"}),
+				Overrides: &types.GitHubAppConfig{InstallationID: "1000"},
+			},
+			wantAppID:          "123",
+			wantInstallationID: "1000",
+		},
+		{
+			name: "optional installation",
+			loader: Loader{
+				LookupEnv:            envMap(map[string]string{EnvAppID: "5", EnvPrivateKey: keyRef}),
+				InstallationOptional: true,
+			},
+			wantAppID: "5",
+		},
+		{
+			name: "legacy variables",
+			loader: Loader{LookupEnv: envMap(map[string]string{
+				EnvAppID:                 "5",
+				"GITHUB_PRIVATE_KEY":     keyRef,
+				"GITHUB_INSTALLATION_ID": "51",
+			})},
+			wantAppID:          "5",
+			wantInstallationID: "51",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.loader.LookupEnv == nil {
+				tt.loader.LookupEnv = envMap(nil)
+			}
+
+			config, err := tt.loader.Load()
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if config.AppID != tt.wantAppID {
+				t.Errorf("Expected app_id %s, got %s", tt.wantAppID, config.AppID)
+			}
+			if config.InstallationID != tt.wantInstallationID {
+				t.Errorf("Expected installation_id %s, got %s", tt.wantInstallationID, config.InstallationID)
+			}
+			if config.BaseURL != tt.wantBaseURL {
+				t.Errorf("Expected base_url %q, got %q", tt.wantBaseURL, config.BaseURL)
+			}
+		})
+	}
+}
+
+func TestLoader_Load_Errors(t *testing.T) {
+	dir := t.TempDir()
+	keyRef := writeTestKey(t, dir)
+
+	noDefault := writeFile(t, dir, "nodefault.yaml", `
+apps:
+  a: {app_id: "1", private_key: `+keyRef+`, installation_id: "1"}
+  b: {app_id: "2", private_key: `+keyRef+`, installation_id: "2"}
+`)
+
+	tests := []struct {
+		name    string
+		loader  Loader
+		wantErr string
+	}{
+		{
+			name:    "missing file",
+			loader:  Loader{Path: filepath.Join(dir, "missing.yaml")},
+			wantErr: "failed to read config file",
+		},
+		{
+			name:    "unknown key",
+			loader:  Loader{Path: writeFile(t, dir, "typo.yaml", "app_idd: \"1\"\n")},
+			wantErr: "failed to parse config file",
+		},
+		{
+			name:    "unknown JSON key",
+			loader:  Loader{Path: writeFile(t, dir, "typo.json", `{"app_idd": "1"}`)},
+			wantErr: "failed to parse config file",
+		},
+		{
+			name:    "ambiguous app",
+			loader:  Loader{Path: noDefault},
+			wantErr: "2 apps are configured",
+		},
+		{
+			name:    "unknown app",
+			loader:  Loader{Path: noDefault, App: "c"},
+			wantErr: `app "c" is not configured`,
+		},
+		{
+			name: "top-level and named apps",
+			loader: Loader{Path: writeFile(t, dir, "mixed.yaml", `
+app_id: "1"
+apps:
+  a: {app_id: "2"}
+`)},
+			wantErr: "cannot be combined",
+		},
+		{
+			name:    "unknown default",
+			loader:  Loader{Path: writeFile(t, dir, "baddefault.yaml", "default: x\napps:\n  a: {app_id: \"1\"}\n")},
+			wantErr: `default app "x" is not configured`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.loader.LookupEnv = envMap(nil)
+
+			_, err := tt.loader.Load()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoader_Load_ReportsAllErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "bad.yaml", `
+app_id: abc
+installation_id: xyz
+base_url: ftp://example.com
+`)
+
+	_, err := (&Loader{Path: path, LookupEnv: envMap(nil)}).Load()
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+
+	for _, want := range []string{"private_key is required", "invalid app_id", "invalid installation_id", "invalid base_url"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got %v", want, err)
+		}
+	}
+	if len(validationErr.Errors) != 4 {
+		t.Errorf("Expected 4 errors, got %d: %v", len(validationErr.Errors), err)
+	}
+}
+
+func TestLoader_LoadAll(t *testing.T) {
+	dir := t.TempDir()
+	keyRef := writeTestKey(t, dir)
+
+	valid := writeFile(t, dir, "valid.yaml", `
+apps:
+  a: {app_id: "1", private_key: `+keyRef+`, installation_id: "1"}
+  b: {app_id: "2", private_key: `+keyRef+`, installation_id: "2"}
+`)
+
+	apps, err := (&Loader{Path: valid, LookupEnv: envMap(nil)}).LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll() error = %v", err)
+	}
+	if len(apps) != 2 {
+		t.Errorf("Expected 2 apps, got %d", len(apps))
+	}
+
+	invalid := writeFile(t, dir, "invalid.yaml", `
+apps:
+  a: {app_id: "1", installation_id: "1"}
+  b: {app_id: "x", private_key: `+keyRef+`, installation_id: "2"}
+`)
+
+	_, err = (&Loader{Path: invalid, LookupEnv: envMap(nil)}).LoadAll()
+	if err == nil {
+		t.Fatal("LoadAll() should fail for invalid apps")
+	}
+	for _, want := range []string{`app "a": private_key is required`, `app "b": invalid app_id`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got %v", want, err)
+		}
+	}
+}
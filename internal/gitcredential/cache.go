@@ -0,0 +1,167 @@
+package gitcredential
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// cacheFileName is the file holding cached credentials inside the cache directory
+const cacheFileName = "git-credentials.json"
+
+// installationTTL bounds how long a repository is assumed to stay on the same
+// installation, since an App can be uninstalled or moved between installations
+const installationTTL = time.Hour
+
+// cachedInstallation is a repository to installation lookup persisted between helper invocations
+type cachedInstallation struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// cachedToken is an installation token persisted between helper invocations
+type cachedToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// cacheData is the on-disk format of the credential cache
+type cacheData struct {
+	// Installations maps "<app>@<host>/<owner>/<repo>" to an installation ID
+	Installations map[string]cachedInstallation `json:"installations,omitempty"`
+	// Tokens maps "<app>@<host>/<installation>" to its latest token
+	Tokens map[string]cachedToken `json:"tokens,omitempty"`
+}
+
+// FileCache persists installation lookups and tokens across helper
+// invocations in a file readable only by the current user. Updates hold a lock
+// on a sibling .lock file so concurrent git processes do not lose each other's
+// entries.
+type FileCache struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewFileCache creates a cache stored in dir, or in the user cache directory
+// (e.g. ~/.cache/ghappauth) when dir is empty
+func NewFileCache(dir string) (*FileCache, error) {
+	if dir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate user cache directory: %w", err)
+		}
+		dir = filepath.Join(userCache, "ghappauth")
+	}
+
+	return &FileCache{path: filepath.Join(dir, cacheFileName)}, nil
+}
+
+// installation returns the cached installation ID for a repository
+func (c *FileCache) installation(key string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	data := c.load()
+	installation, ok := data.Installations[key]
+	if !ok || !installation.ExpiresAt.After(time.Now()) {
+		return "", false
+	}
+	return installation.ID, true
+}
+
+// token returns the cached token for an installation
+func (c *FileCache) token(key string) (cachedToken, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	data := c.load()
+	token, ok := data.Tokens[key]
+	return token, ok
+}
+
+// update applies fn to the cached data and writes the result back, dropping
+// expired tokens and installation lookups. The file is re-read under the lock
+// so entries written by other processes are kept.
+func (c *FileCache) update(fn func(data *cacheData)) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	unlock, err := lockFile(c.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock credential cache: %w", err)
+	}
+	defer unlock()
+
+	data := c.load()
+	fn(data)
+
+	now := time.Now()
+	for key, token := range data.Tokens {
+		if !token.ExpiresAt.After(now) {
+			delete(data.Tokens, key)
+		}
+	}
+	for key, installation := range data.Installations {
+		if !installation.ExpiresAt.After(now) {
+			delete(data.Installations, key)
+		}
+	}
+
+	return c.save(data)
+}
+
+// load reads the cache file, treating a missing or corrupt file as empty
+func (c *FileCache) load() *cacheData {
+	data := &cacheData{}
+
+	content, err := os.ReadFile(c.path)
+	if err == nil {
+		if err := json.Unmarshal(content, data); err != nil {
+			data = &cacheData{}
+		}
+	}
+
+	if data.Installations == nil {
+		data.Installations = make(map[string]cachedInstallation)
+	}
+	if data.Tokens == nil {
+		data.Tokens = make(map[string]cachedToken)
+	}
+
+	return data
+}
+
+// save writes the cache atomically so concurrent git processes never see a partial file
+func (c *FileCache) save(data *cacheData) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode credential cache: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), cacheFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write credential cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credential cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write credential cache: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to write credential cache: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package gitcredential
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileCache_InstallationExpiry(t *testing.T) {
+	cache, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileCache() error = %v", err)
+	}
+
+	err = cache.update(func(data *cacheData) {
+		data.Installations["fresh"] = cachedInstallation{ID: "1", ExpiresAt: time.Now().Add(time.Hour)}
+		data.Installations["expired"] = cachedInstallation{ID: "2", ExpiresAt: time.Now().Add(-time.Second)}
+	})
+	if err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+
+	if id, ok := cache.installation("fresh"); !ok || id != "1" {
+		t.Errorf("installation(fresh) = %q, %v; want 1, true", id, ok)
+	}
+	if _, ok := cache.installation("expired"); ok {
+		t.Error("installation(expired) should miss")
+	}
+	if _, ok := cache.load().Installations["expired"]; ok {
+		t.Error("update() should drop expired installations from the file")
+	}
+}
+
+func TestFileCache_ConcurrentUpdates(t *testing.T) {
+	dir := t.TempDir()
+
+	// Separate FileCache values stand in for separate git processes, which
+	// do not share the in-process mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			cache, err := NewFileCache(dir)
+			if err != nil {
+				t.Errorf("NewFileCache() error = %v", err)
+				return
+			}
+			err = cache.update(func(data *cacheData) {
+				data.Tokens[fmt.Sprintf("app@host/%d", i)] = cachedToken{Token: "ghs", ExpiresAt: time.Now().Add(time.Hour)}
+			})
+			if err != nil {
+				t.Errorf("update() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	cache, _ := NewFileCache(dir)
+	if tokens := len(cache.load().Tokens); tokens != 20 {
+		t.Errorf("Expected all 20 updates to be kept, got %d", tokens)
+	}
+}
@@ -0,0 +1,120 @@
+package gitcredential
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Credential is a set of attributes exchanged with git over the credential
+// helper protocol, see gitcredentials(7)
+type Credential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+	// PasswordExpiry is the password expiry as a Unix timestamp, understood by git 2.41+
+	PasswordExpiry string
+}
+
+// Read parses key=value lines from r until a blank line or EOF
+func Read(r io.Reader) (*Credential, error) {
+	credential := &Credential{}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid credential line %q", line)
+		}
+
+		switch key {
+		case "protocol":
+			credential.Protocol = value
+		case "host":
+			credential.Host = value
+		case "path":
+			credential.Path = value
+		case "username":
+			credential.Username = value
+		case "password":
+			credential.Password = value
+		case "password_expiry_utc":
+			credential.PasswordExpiry = value
+		case "url":
+			if err := credential.setURL(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read credential: %w", err)
+	}
+
+	return credential, nil
+}
+
+// setURL fills the protocol, host and path attributes from a url attribute
+func (c *Credential) setURL(value string) error {
+	protocol, rest, ok := strings.Cut(value, "://")
+	if !ok {
+		return fmt.Errorf("invalid credential url %q", value)
+	}
+
+	host, path, _ := strings.Cut(rest, "/")
+	if _, after, ok := strings.Cut(host, "@"); ok {
+		host = after
+	}
+
+	c.Protocol = protocol
+	c.Host = host
+	c.Path = path
+	return nil
+}
+
+// Write writes the non-empty attributes to w as key=value lines
+func (c *Credential) Write(w io.Writer) error {
+	attributes := []struct{ key, value string }{
+		{"protocol", c.Protocol},
+		{"host", c.Host},
+		{"path", c.Path},
+		{"username", c.Username},
+		{"password", c.Password},
+		{"password_expiry_utc", c.PasswordExpiry},
+	}
+
+	for _, attribute := range attributes {
+		if attribute.value == "" {
+			continue
+		}
+		if strings.ContainsAny(attribute.value, "\n\x00") {
+			return fmt.Errorf("credential %s contains an invalid character", attribute.key)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", attribute.key, attribute.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Owner returns the repository owner and name from the path attribute, which
+// git only sends when credential.useHttpPath is enabled
+func (c *Credential) Owner() (owner string, repo string, ok bool) {
+	path := strings.Trim(c.Path, "/")
+	owner, repo, ok = strings.Cut(path, "/")
+	if !ok || owner == "" {
+		return strings.TrimSuffix(path, ".git"), "", path != ""
+	}
+
+	repo, _, _ = strings.Cut(repo, "/")
+	return owner, strings.TrimSuffix(repo, ".git"), true
+}
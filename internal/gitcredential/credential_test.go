@@ -0,0 +1,75 @@
+package gitcredential
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	input := "protocol=https\nhost=github.com\npath=octo/repo.git\nwwwauth[]=Basic\n\nprotocol=ignored\n"
+
+	credential, err := Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	want := Credential{Protocol: "https", Host: "github.com", Path: "octo/repo.git"}
+	if *credential != want {
+		t.Errorf("Read() = %+v, want %+v", *credential, want)
+	}
+}
+
+func TestRead_URL(t *testing.T) {
+	credential, err := Read(strings.NewReader("url=https://user@github.example.com:8443/octo/repo\n"))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	want := Credential{Protocol: "https", Host: "github.example.com:8443", Path: "octo/repo"}
+	if *credential != want {
+		t.Errorf("Read() = %+v, want %+v", *credential, want)
+	}
+
+	if _, err := Read(strings.NewReader("invalid\n")); err == nil {
+		t.Error("Read() should fail on lines without '='")
+	}
+}
+
+func TestCredential_Write(t *testing.T) {
+	var buf bytes.Buffer
+	credential := &Credential{Protocol: "https", Host: "github.com", Username: "x-access-token", Password: "ghs_test", PasswordExpiry: "1700000000"}
+
+	if err := credential.Write(&buf); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	want := "protocol=https\nhost=github.com\nusername=x-access-token\npassword=ghs_test\npassword_expiry_utc=1700000000\n"
+	if buf.String() != want {
+		t.Errorf("Write() = %q, want %q", buf.String(), want)
+	}
+
+	if err := (&Credential{Password: "a\nb"}).Write(&buf); err == nil {
+		t.Error("Write() should reject values containing newlines")
+	}
+}
+
+func TestCredential_Owner(t *testing.T) {
+	tests := []struct {
+		path        string
+		owner, repo string
+		ok          bool
+	}{
+		{"octo/repo.git", "octo", "repo", true},
+		{"/octo/repo/info/refs", "octo", "repo", true},
+		{"octo", "octo", "", true},
+		{"", "", "", false},
+	}
+
+	for _, tt := range tests {
+		owner, repo, ok := (&Credential{Path: tt.path}).Owner()
+		if owner != tt.owner || repo != tt.repo || ok != tt.ok {
+			t.Errorf("Owner(%q) = (%q, %q, %v), want (%q, %q, %v)", tt.path, owner, repo, ok, tt.owner, tt.repo, tt.ok)
+		}
+	}
+}
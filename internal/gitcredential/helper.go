@@ -0,0 +1,238 @@
+package gitcredential
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ghappauth/internal/auth"
+	"ghappauth/internal/types"
+)
+
+// tokenUsername is the username git sends along with an installation token
+const tokenUsername = "x-access-token"
+
+// Helper answers git credential requests with installation tokens for the
+// repositories an App is installed on
+type Helper struct {
+	auth   *auth.GitHubAppAuth
+	tokens *auth.TokenManager
+	cache  *FileCache
+}
+
+// NewHelper creates a credential helper. The cache is optional; without it
+// every invocation mints a new token.
+func NewHelper(githubAuth *auth.GitHubAppAuth, tokens *auth.TokenManager, cache *FileCache) *Helper {
+	return &Helper{
+		auth:   githubAuth,
+		tokens: tokens,
+		cache:  cache,
+	}
+}
+
+// Run handles one git credential operation, reading the request from stdin
+// and writing any response to stdout
+func (h *Helper) Run(ctx context.Context, operation string, stdin io.Reader, stdout io.Writer) error {
+	request, err := Read(stdin)
+	if err != nil {
+		return err
+	}
+
+	switch operation {
+	case "get":
+		response, err := h.Get(ctx, request)
+		if err != nil || response == nil {
+			return err
+		}
+		return response.Write(stdout)
+	case "erase":
+		return h.Erase(request)
+	case "store":
+		// Tokens are minted on demand, there is nothing to store
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %q", operation)
+	}
+}
+
+// Get returns credentials for the requested repository, or nil when the
+// request is for a host this App does not serve so git can try other helpers
+func (h *Helper) Get(ctx context.Context, request *Credential) (*Credential, error) {
+	if !h.matches(request) {
+		return nil, nil
+	}
+
+	installationID, err := h.installationID(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	token, expiresAt, err := h.token(installationID)
+	if err != nil {
+		if staleInstallation(err) {
+			if forgetErr := h.forgetInstallation(request); forgetErr != nil {
+				return nil, errors.Join(err, forgetErr)
+			}
+		}
+		return nil, err
+	}
+
+	return &Credential{
+		Protocol:       request.Protocol,
+		Host:           request.Host,
+		Path:           request.Path,
+		Username:       tokenUsername,
+		Password:       token,
+		PasswordExpiry: strconv.FormatInt(expiresAt.Unix(), 10),
+	}, nil
+}
+
+// Erase forgets a token git reports as rejected, along with the installation
+// the repository was resolved to in case it moved
+func (h *Helper) Erase(request *Credential) error {
+	if !h.matches(request) || h.cache == nil {
+		return nil
+	}
+
+	prefix := h.cachePrefix() + "/"
+	installationKey, hasInstallation := h.installationKey(request)
+	return h.cache.update(func(data *cacheData) {
+		if hasInstallation {
+			delete(data.Installations, installationKey)
+		}
+
+		if request.Password == "" {
+			return
+		}
+		for key, token := range data.Tokens {
+			if strings.HasPrefix(key, prefix) && token.Token == request.Password {
+				delete(data.Tokens, key)
+			}
+		}
+	})
+}
+
+// matches reports whether the request is for the GitHub host this App uses
+func (h *Helper) matches(request *Credential) bool {
+	endpoints := h.auth.Endpoints()
+	webURL, err := url.Parse(endpoints.WebURL)
+	if err != nil {
+		return false
+	}
+
+	if request.Protocol != "" && request.Protocol != webURL.Scheme {
+		return false
+	}
+
+	return strings.EqualFold(request.Host, webURL.Host)
+}
+
+// installationID resolves the installation that can access the requested
+// repository, falling back to the configured installation when git does not
+// send the repository path
+func (h *Helper) installationID(ctx context.Context, request *Credential) (string, error) {
+	owner, repo, ok := request.Owner()
+	if !ok || repo == "" {
+		if id := h.auth.InstallationID(); id != "" {
+			return id, nil
+		}
+		return "", fmt.Errorf("cannot determine the installation for %s, set credential.useHttpPath=true or configure installation_id", request.Host)
+	}
+
+	key, _ := h.installationKey(request)
+	if h.cache != nil {
+		if id, ok := h.cache.installation(key); ok {
+			return id, nil
+		}
+	}
+
+	installation, err := h.auth.GetRepositoryInstallation(ctx, owner, repo)
+	if err != nil {
+		return "", err
+	}
+
+	id := strconv.Itoa(installation.ID)
+	if h.cache != nil {
+		err := h.cache.update(func(data *cacheData) {
+			data.Installations[key] = cachedInstallation{ID: id, ExpiresAt: time.Now().Add(installationTTL)}
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// forgetInstallation drops the cached installation of the requested repository
+func (h *Helper) forgetInstallation(request *Credential) error {
+	key, ok := h.installationKey(request)
+	if !ok || h.cache == nil {
+		return nil
+	}
+
+	return h.cache.update(func(data *cacheData) { delete(data.Installations, key) })
+}
+
+// installationKey returns the cache key of the repository in request, if it names one
+func (h *Helper) installationKey(request *Credential) (string, bool) {
+	owner, repo, ok := request.Owner()
+	if !ok || repo == "" {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s/%s/%s", h.cachePrefix(), strings.ToLower(owner), strings.ToLower(repo)), true
+}
+
+// staleInstallation reports whether minting failed because the installation
+// is gone or no longer belongs to the App
+func staleInstallation(err error) bool {
+	var apiErr *auth.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusUnauthorized
+}
+
+// token returns a cached token for the installation that is not about to
+// expire, or mints a new one through the TokenManager
+func (h *Helper) token(installationID string) (string, time.Time, error) {
+	key := h.cachePrefix() + "/" + installationID
+
+	if h.cache != nil {
+		cached, ok := h.cache.token(key)
+		if ok && !h.tokens.IsTokenExpired(&types.GitHubAppToken{ExpiresAt: cached.ExpiresAt}, h.tokens.GetRenewBuffer()) {
+			return cached.Token, cached.ExpiresAt, nil
+		}
+	}
+
+	token, err := h.tokens.GetTokenForInstallation(installationID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if h.cache != nil {
+		err := h.cache.update(func(data *cacheData) {
+			data.Tokens[key] = cachedToken{Token: token.Token, ExpiresAt: token.ExpiresAt}
+		})
+		if err != nil {
+			return "", time.Time{}, err
+		}
+	}
+
+	return token.Token, token.ExpiresAt, nil
+}
+
+// cachePrefix scopes cache entries to this App and host
+func (h *Helper) cachePrefix() string {
+	host := h.auth.Endpoints().WebURL
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	return h.auth.AppID() + "@" + host
+}
@@ -0,0 +1,198 @@
+package gitcredential
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ghappauth/internal/auth"
+	"ghappauth/internal/types"
+)
+
+type testServer struct {
+	*httptest.Server
+	lookups int32
+	mints   int32
+}
+
+// newTestServer fakes a GitHub API that has the App installed on octo/repo
+// with installation 42
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	server := &testServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/octo/repo/installation":
+			atomic.AddInt32(&server.lookups, 1)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"id": 42}`))
+		case r.Method == "POST" && r.URL.Path == "/app/installations/42/access_tokens":
+			n := atomic.AddInt32(&server.mints, 1)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"token": "ghs_%d", "expires_at": "2030-01-01T00:00:00Z"}`, n)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newTestHelper(t *testing.T, baseURL string, installationID string, cache *FileCache) *Helper {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	githubAuth, err := auth.NewAppAuth(&types.GitHubAppConfig{
+		AppID:          "12345",
+		PrivateKey:     string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		InstallationID: installationID,
+		BaseURL:        baseURL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	return NewHelper(githubAuth, auth.NewTokenManager(githubAuth, 0), cache)
+}
+
+func TestHelper_Get(t *testing.T) {
+	server := newTestServer(t)
+	cache, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileCache() error = %v", err)
+	}
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	input := "protocol=http\nhost=" + host + "\npath=octo/repo.git\n\n"
+
+	var out bytes.Buffer
+	if err := newTestHelper(t, server.URL, "", cache).Run(context.Background(), "get", strings.NewReader(input), &out); err != nil {
+		t.Fatalf("Run(get) error = %v", err)
+	}
+
+	response, err := Read(&out)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.Username != tokenUsername || response.Password != "ghs_1" {
+		t.Errorf("Expected x-access-token/ghs_1, got %s/%s", response.Username, response.Password)
+	}
+	if response.PasswordExpiry != "1893456000" {
+		t.Errorf("Expected password expiry 1893456000, got %s", response.PasswordExpiry)
+	}
+
+	// A new process reuses the installation lookup and token from the cache
+	out.Reset()
+	if err := newTestHelper(t, server.URL, "", cache).Run(context.Background(), "get", strings.NewReader(input), &out); err != nil {
+		t.Fatalf("Run(get) error = %v", err)
+	}
+	if !strings.Contains(out.String(), "password=ghs_1\n") {
+		t.Errorf("Expected cached token, got %q", out.String())
+	}
+	if lookups, mints := atomic.LoadInt32(&server.lookups), atomic.LoadInt32(&server.mints); lookups != 1 || mints != 1 {
+		t.Errorf("Expected 1 lookup and 1 mint, got %d and %d", lookups, mints)
+	}
+
+	// After git rejects the token a fresh one is minted
+	erase := "protocol=http\nhost=" + host + "\npath=octo/repo.git\nusername=x-access-token\npassword=ghs_1\n\n"
+	if err := newTestHelper(t, server.URL, "", cache).Run(context.Background(), "erase", strings.NewReader(erase), &out); err != nil {
+		t.Fatalf("Run(erase) error = %v", err)
+	}
+
+	out.Reset()
+	if err := newTestHelper(t, server.URL, "", cache).Run(context.Background(), "get", strings.NewReader(input), &out); err != nil {
+		t.Fatalf("Run(get) error = %v", err)
+	}
+	if !strings.Contains(out.String(), "password=ghs_2\n") {
+		t.Errorf("Expected a new token after erase, got %q", out.String())
+	}
+	if lookups := atomic.LoadInt32(&server.lookups); lookups != 2 {
+		t.Errorf("Expected erase to drop the installation lookup, got %d lookups", lookups)
+	}
+}
+
+func TestHelper_Get_InstallationMoved(t *testing.T) {
+	server := newTestServer(t)
+	cache, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileCache() error = %v", err)
+	}
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	request := &Credential{Protocol: "http", Host: host, Path: "octo/repo.git"}
+
+	// The repository was cached on installation 7, which no longer exists
+	helper := newTestHelper(t, server.URL, "", cache)
+	key, _ := helper.installationKey(request)
+	err = cache.update(func(data *cacheData) {
+		data.Installations[key] = cachedInstallation{ID: "7", ExpiresAt: time.Now().Add(time.Hour)}
+	})
+	if err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+
+	if _, err := helper.Get(context.Background(), request); err == nil {
+		t.Fatal("Get() should fail for a removed installation")
+	}
+	if _, ok := cache.installation(key); ok {
+		t.Error("The stale installation should be dropped from the cache")
+	}
+
+	response, err := newTestHelper(t, server.URL, "", cache).Get(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if response.Password != "ghs_1" {
+		t.Errorf("Expected a token for installation 42, got %q", response.Password)
+	}
+	if id, _ := cache.installation(key); id != "42" {
+		t.Errorf("Expected installation 42 to be cached, got %q", id)
+	}
+}
+
+func TestHelper_Get_OtherHost(t *testing.T) {
+	server := newTestServer(t)
+	helper := newTestHelper(t, server.URL, "42", nil)
+
+	response, err := helper.Get(context.Background(), &Credential{Protocol: "https", Host: "gitlab.com", Path: "octo/repo"})
+	if err != nil || response != nil {
+		t.Errorf("Get() for another host = (%v, %v), want (nil, nil)", response, err)
+	}
+	if atomic.LoadInt32(&server.mints) != 0 {
+		t.Error("No token should be minted for another host")
+	}
+}
+
+func TestHelper_Get_WithoutPath(t *testing.T) {
+	server := newTestServer(t)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	response, err := newTestHelper(t, server.URL, "42", nil).Get(context.Background(), &Credential{Protocol: "http", Host: host})
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if response.Password != "ghs_1" {
+		t.Errorf("Expected token for the configured installation, got %q", response.Password)
+	}
+
+	if _, err := newTestHelper(t, server.URL, "", nil).Get(context.Background(), &Credential{Protocol: "http", Host: host}); err == nil {
+		t.Error("Get() should fail without a path or configured installation")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package gitcredential
+
+// lockFile is only implemented where flock is available; elsewhere updates
+// are serialized within the process only and concurrent helpers may drop an
+// entry, which just costs an extra lookup or token on the next request
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
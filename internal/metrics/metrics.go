@@ -0,0 +1,62 @@
+// Package metrics counts token and GitHub API activity and exports it in the
+// Prometheus text format.
+package metrics
+
+import (
+	"time"
+)
+
+// Recorder receives token and HTTP measurements. Implementations must be safe
+// for concurrent use and return quickly, as they are called on the request path.
+//
+// Implementations must embed NopRecorder, which the unexported method
+// enforces. Methods added to Recorder in later versions then default to doing
+// nothing instead of breaking existing implementations.
+type Recorder interface {
+	// TokenMinted records a request for a new installation token; err is nil on success
+	TokenMinted(err error)
+	// TokenRenewed records the renewal of a cached token that was about to expire
+	TokenRenewed(err error)
+	// TokenServedStale records a cached token served within its renew buffer
+	// because its renewal failed or is backed off after a failure
+	TokenServedStale()
+	// TokenCacheHit records a token served from the cache
+	TokenCacheHit()
+	// TokenCacheMiss records a token request that found nothing in the cache
+	TokenCacheMiss()
+	// TokenCacheEviction records a token removed from the cache, because the
+	// cache was full ("capacity") or the token had expired ("expired")
+	TokenCacheEviction(reason string)
+	// HTTPRequest records one attempt of a GitHub API request. endpoint is a
+	// route template such as /app/installations/{installation_id}, and status
+	// is 0 when no response was received.
+	HTTPRequest(method, endpoint string, status int, duration time.Duration)
+	// HTTPRetry records that a GitHub API request is retried
+	HTTPRetry(method, endpoint string)
+	// RateLimit records the rate limit GitHub reported for a resource
+	RateLimit(resource string, limit, remaining int)
+	// KeyReloaded records an attempt to reload a rotated private key file;
+	// err is nil on success
+	KeyReloaded(err error)
+
+	nopRecorder()
+}
+
+// NopRecorder is a Recorder that ignores everything. Embed it in Recorder
+// implementations and override the methods of interest.
+type NopRecorder struct{}
+
+// Discard is a Recorder that ignores everything
+var Discard Recorder = NopRecorder{}
+
+func (NopRecorder) TokenMinted(error)                              {}
+func (NopRecorder) TokenRenewed(error)                             {}
+func (NopRecorder) TokenServedStale()                              {}
+func (NopRecorder) TokenCacheHit()                                 {}
+func (NopRecorder) TokenCacheMiss()                                {}
+func (NopRecorder) TokenCacheEviction(string)                      {}
+func (NopRecorder) HTTPRequest(string, string, int, time.Duration) {}
+func (NopRecorder) HTTPRetry(string, string)                       {}
+func (NopRecorder) RateLimit(string, int, int)                     {}
+func (NopRecorder) KeyReloaded(error)                              {}
+func (NopRecorder) nopRecorder()                                   {}
@@ -0,0 +1,322 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the request latency histogram
+var DefaultBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Metric kinds, as named in the Prometheus text format
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// Registry is a Recorder that keeps the measurements in memory and renders
+// them in the Prometheus text exposition format
+type Registry struct {
+	NopRecorder
+
+	mutex    sync.Mutex
+	families []*family
+
+	tokenMints          *family
+	tokenRenewals       *family
+	tokenStale          *family
+	tokenCacheHits      *family
+	tokenCacheMisses    *family
+	tokenCacheEvictions *family
+	httpRequests        *family
+	httpRequestDuration *family
+	httpRetries         *family
+	rateLimit           *family
+	rateLimitRemaining  *family
+	keyReloads          *family
+}
+
+// family is a metric with all of its label combinations
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+// series is one label combination of a metric
+type series struct {
+	labelValues []string
+	value       float64  // Counter or gauge value
+	counts      []uint64 // Histogram observations per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+// NewRegistry creates an empty registry using DefaultBuckets for latencies
+func NewRegistry() *Registry {
+	r := &Registry{}
+
+	r.tokenMints = r.newFamily("ghappauth_token_mints_total", kindCounter,
+		"Installation tokens requested from GitHub, by result.", "result")
+	r.tokenRenewals = r.newFamily("ghappauth_token_renewals_total", kindCounter,
+		"Cached installation tokens renewed before expiry, by result.", "result")
+	r.tokenStale = r.newFamily("ghappauth_token_stale_served_total", kindCounter,
+		"Cached installation tokens served after their renewal failed.")
+	r.tokenCacheHits = r.newFamily("ghappauth_token_cache_hits_total", kindCounter,
+		"Installation tokens served from the cache.")
+	r.tokenCacheMisses = r.newFamily("ghappauth_token_cache_misses_total", kindCounter,
+		"Installation token requests not found in the cache.")
+	r.tokenCacheEvictions = r.newFamily("ghappauth_token_cache_evictions_total", kindCounter,
+		"Installation tokens removed from the cache, by reason.", "reason")
+	r.httpRequests = r.newFamily("ghappauth_http_requests_total", kindCounter,
+		"GitHub API request attempts, by method, endpoint and status.", "method", "endpoint", "status")
+	r.httpRequestDuration = r.newFamily("ghappauth_http_request_duration_seconds", kindHistogram,
+		"Latency of GitHub API request attempts, by method, endpoint and status.", "method", "endpoint", "status")
+	r.httpRequestDuration.buckets = DefaultBuckets
+	r.httpRetries = r.newFamily("ghappauth_http_retries_total", kindCounter,
+		"GitHub API requests retried, by method and endpoint.", "method", "endpoint")
+	r.rateLimit = r.newFamily("ghappauth_rate_limit", kindGauge,
+		"Requests allowed per hour by the GitHub API rate limit, by resource.", "resource")
+	r.rateLimitRemaining = r.newFamily("ghappauth_rate_limit_remaining", kindGauge,
+		"Requests left in the current GitHub API rate limit window, by resource.", "resource")
+
+	r.keyReloads = r.newFamily("ghappauth_key_reloads_total", kindCounter,
+		"Reloads of a rotated private key file, by result.", "result")
+
+	return r
+}
+
+func (r *Registry) newFamily(name, kind, help string, labels ...string) *family {
+	f := &family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// TokenMinted implements Recorder
+func (r *Registry) TokenMinted(err error) {
+	r.add(r.tokenMints, 1, result(err))
+}
+
+// TokenRenewed implements Recorder
+func (r *Registry) TokenRenewed(err error) {
+	r.add(r.tokenRenewals, 1, result(err))
+}
+
+// TokenServedStale implements Recorder
+func (r *Registry) TokenServedStale() {
+	r.add(r.tokenStale, 1)
+}
+
+// KeyReloaded implements Recorder
+func (r *Registry) KeyReloaded(err error) {
+	r.add(r.keyReloads, 1, result(err))
+}
+
+// TokenCacheHit implements Recorder
+func (r *Registry) TokenCacheHit() {
+	r.add(r.tokenCacheHits, 1)
+}
+
+// TokenCacheMiss implements Recorder
+func (r *Registry) TokenCacheMiss() {
+	r.add(r.tokenCacheMisses, 1)
+}
+
+// TokenCacheEviction implements Recorder
+func (r *Registry) TokenCacheEviction(reason string) {
+	r.add(r.tokenCacheEvictions, 1, reason)
+}
+
+// HTTPRequest implements Recorder
+func (r *Registry) HTTPRequest(method, endpoint string, status int, duration time.Duration) {
+	statusLabel := "error"
+	if status != 0 {
+		statusLabel = strconv.Itoa(status)
+	}
+
+	r.add(r.httpRequests, 1, method, endpoint, statusLabel)
+	r.observe(r.httpRequestDuration, duration.Seconds(), method, endpoint, statusLabel)
+}
+
+// HTTPRetry implements Recorder
+func (r *Registry) HTTPRetry(method, endpoint string) {
+	r.add(r.httpRetries, 1, method, endpoint)
+}
+
+// RateLimit implements Recorder
+func (r *Registry) RateLimit(resource string, limit, remaining int) {
+	if resource == "" {
+		resource = "core"
+	}
+
+	r.set(r.rateLimit, float64(limit), resource)
+	r.set(r.rateLimitRemaining, float64(remaining), resource)
+}
+
+// result labels an outcome as success or failure
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// get returns the series of f for the label values, creating it if needed;
+// callers must hold mutex
+func (f *family) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: labelValues}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (r *Registry) add(f *family, delta float64, labelValues ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f.get(labelValues).value += delta
+}
+
+func (r *Registry) set(f *family, value float64, labelValues ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f.get(labelValues).value = value
+}
+
+func (r *Registry) observe(f *family, value float64, labelValues ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s := f.get(labelValues)
+	s.count++
+	s.sum += value
+	if i := sort.SearchFloat64s(f.buckets, value); i < len(f.buckets) {
+		s.counts[i]++
+	}
+}
+
+// familySnapshot is a copy of the series of a family, sorted by label values
+type familySnapshot struct {
+	family *family // Only the fields set by newFamily are read
+	series []series
+}
+
+// snapshot copies every family that has been recorded, so it can be written
+// without holding mutex
+func (r *Registry) snapshot() []familySnapshot {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var snapshots []familySnapshot
+	for _, f := range r.families {
+		if len(f.series) == 0 {
+			continue
+		}
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		snapshot := familySnapshot{family: f, series: make([]series, len(keys))}
+		for i, key := range keys {
+			s := *f.series[key]
+			s.counts = slices.Clone(s.counts)
+			snapshot.series[i] = s
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+// WriteText writes every metric that has been recorded in the Prometheus text
+// exposition format. The registry is not locked while w is written to, so a
+// slow reader does not hold up recording.
+func (r *Registry) WriteText(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	for _, snapshot := range r.snapshot() {
+		f := snapshot.family
+		fmt.Fprintf(buf, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+
+		for _, s := range snapshot.series {
+			if f.kind != kindHistogram {
+				fmt.Fprintf(buf, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues), formatValue(s.value))
+				continue
+			}
+
+			bucketLabels := append(append([]string(nil), f.labels...), "le")
+			bucketValues := append(append([]string(nil), s.labelValues...), "")
+			var cumulative uint64
+			for i, bound := range f.buckets {
+				cumulative += s.counts[i]
+				bucketValues[len(bucketValues)-1] = formatValue(bound)
+				fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, formatLabels(bucketLabels, bucketValues), cumulative)
+			}
+			bucketValues[len(bucketValues)-1] = "+Inf"
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, formatLabels(bucketLabels, bucketValues), s.count)
+			fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues), formatValue(s.sum))
+			fmt.Fprintf(buf, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues), s.count)
+		}
+	}
+
+	return buf.Flush()
+}
+
+// Handler returns an http.Handler that serves the metrics for scraping
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// formatLabels renders label pairs as {name="value",...}, or "" without labels
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper escapes label values as the text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
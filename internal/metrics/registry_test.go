@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	registry := NewRegistry()
+
+	registry.TokenMinted(nil)
+	registry.TokenMinted(errors.New("boom"))
+	registry.TokenCacheHit()
+	registry.TokenCacheHit()
+	registry.HTTPRequest("POST", "/app/installations/{installation_id}/access_tokens", 201, 30*time.Millisecond)
+	registry.HTTPRequest("POST", "/app/installations/{installation_id}/access_tokens", 0, 2*time.Second)
+	registry.HTTPRetry("POST", "/app/installations/{installation_id}/access_tokens")
+	registry.RateLimit("", 5000, 4990)
+
+	var buf bytes.Buffer
+	if err := registry.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE ghappauth_token_mints_total counter\n",
+		`ghappauth_token_mints_total{result="failure"} 1` + "\n",
+		`ghappauth_token_mints_total{result="success"} 1` + "\n",
+		"ghappauth_token_cache_hits_total 2\n",
+		`ghappauth_http_requests_total{method="POST",endpoint="/app/installations/{installation_id}/access_tokens",status="error"} 1` + "\n",
+		"# TYPE ghappauth_http_request_duration_seconds histogram\n",
+		`ghappauth_http_request_duration_seconds_bucket{method="POST",endpoint="/app/installations/{installation_id}/access_tokens",status="201",le="0.025"} 0` + "\n",
+		`ghappauth_http_request_duration_seconds_bucket{method="POST",endpoint="/app/installations/{installation_id}/access_tokens",status="201",le="0.05"} 1` + "\n",
+		`ghappauth_http_request_duration_seconds_bucket{method="POST",endpoint="/app/installations/{installation_id}/access_tokens",status="201",le="+Inf"} 1` + "\n",
+		`ghappauth_http_request_duration_seconds_count{method="POST",endpoint="/app/installations/{installation_id}/access_tokens",status="error"} 1` + "\n",
+		`ghappauth_http_retries_total{method="POST",endpoint="/app/installations/{installation_id}/access_tokens"} 1` + "\n",
+		`ghappauth_rate_limit_remaining{resource="core"} 4990` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q:\n%s", want, out)
+		}
+	}
+
+	if strings.Contains(out, "ghappauth_token_renewals_total") {
+		t.Errorf("Metrics without measurements should be omitted:\n%s", out)
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	registry := NewRegistry()
+	registry.TokenCacheMiss()
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(recorder.Body.String(), "ghappauth_token_cache_misses_total 1\n") {
+		t.Errorf("Unexpected body:\n%s", recorder.Body.String())
+	}
+}
+
+// blockingWriter blocks every write until release is closed
+type blockingWriter struct {
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	select {
+	case w.writing <- struct{}{}:
+	default:
+	}
+	<-w.release
+	return len(p), nil
+}
+
+func TestRegistry_WriteTextDoesNotBlockRecording(t *testing.T) {
+	r := NewRegistry()
+	r.TokenMinted(nil)
+
+	w := &blockingWriter{writing: make(chan struct{}, 1), release: make(chan struct{})}
+	written := make(chan error, 1)
+	go func() { written <- r.WriteText(w) }()
+	<-w.writing
+
+	recorded := make(chan struct{})
+	go func() {
+		r.TokenMinted(nil)
+		r.HTTPRequest("GET", "/app", 200, time.Millisecond)
+		close(recorded)
+	}()
+
+	select {
+	case <-recorded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Recording blocked while a scrape was being written")
+	}
+
+	close(w.release)
+	if err := <-written; err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+}
+
+func TestFormatLabels_Escaping(t *testing.T) {
+	got := formatLabels([]string{"endpoint"}, []string{"a\"b\\c\nd"})
+	if want := `{endpoint="a\"b\\c\nd"}`; got != want {
+		t.Errorf("formatLabels() = %s, want %s", got, want)
+	}
+}
+
+// mintCounter overrides one method and inherits the others from NopRecorder
+type mintCounter struct {
+	NopRecorder
+	mints int
+}
+
+func (c *mintCounter) TokenMinted(error) { c.mints++ }
+
+func TestNopRecorder_Embedding(t *testing.T) {
+	counter := &mintCounter{}
+	var recorder Recorder = counter
+
+	recorder.TokenMinted(nil)
+	recorder.TokenCacheHit()
+	recorder.TokenCacheEviction("capacity")
+	if counter.mints != 1 {
+		t.Errorf("Expected 1 mint, got %d", counter.mints)
+	}
+}
@@ -0,0 +1,18 @@
+package policy
+
+import "time"
+
+// Decision records the outcome of evaluating a token request
+type Decision struct {
+	Time           time.Time         `json:"time"`
+	Caller         string            `json:"caller,omitempty"`
+	InstallationID string            `json:"installation_id"`
+	Repositories   []string          `json:"repositories,omitempty"`
+	RepositoryIDs  []int             `json:"repository_ids,omitempty"`
+	Permissions    map[string]string `json:"permissions,omitempty"`
+	Allowed        bool              `json:"allowed"`
+	// Rule is the rule that allowed the request, or the first applicable
+	// rule that refused it
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
@@ -0,0 +1,290 @@
+// Package policy limits which installation tokens may be created, by caller,
+// installation, repository and permission level.
+package policy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"ghappauth/internal/types"
+)
+
+// ErrDenied matches errors returned for token requests the policy does not allow
+var ErrDenied = errors.New("token request denied")
+
+// permissionLevels orders permission levels from least to most access
+var permissionLevels = map[string]int{
+	"read":  1,
+	"write": 2,
+	"admin": 3,
+}
+
+// ValidLevel reports whether level is a known permission level
+func ValidLevel(level string) bool {
+	return permissionLevels[level] > 0
+}
+
+// LevelAllows reports whether a permission granted at max covers level
+func LevelAllows(max, level string) bool {
+	return ValidLevel(level) && permissionLevels[level] <= permissionLevels[max]
+}
+
+// Rule allows matching callers to request tokens within its limits. Empty
+// fields do not restrict that dimension.
+type Rule struct {
+	// Name identifies the rule in decisions and errors
+	Name string `yaml:"name" json:"name"`
+	// Callers lists the callers or purposes the rule applies to, as
+	// path.Match patterns (e.g. "ci-*"). A rule without callers applies to
+	// every request, including ones without a caller.
+	Callers []string `yaml:"callers,omitempty" json:"callers,omitempty"`
+	// Installations lists the installation IDs that may be used
+	Installations []string `yaml:"installations,omitempty" json:"installations,omitempty"`
+	// Repositories lists repository name patterns the token may be limited
+	// to. When set, requests must name only matching repositories.
+	Repositories []string `yaml:"repositories,omitempty" json:"repositories,omitempty"`
+	// RepositoryIDs lists repository IDs the token may be limited to
+	RepositoryIDs []int `yaml:"repository_ids,omitempty" json:"repository_ids,omitempty"`
+	// Permissions is the most that may be requested for each permission.
+	// When set, requests must list their permissions and stay within these.
+	Permissions map[string]string `yaml:"permissions,omitempty" json:"permissions,omitempty"`
+}
+
+// Policy allows a token request when any rule matching the caller allows it
+// and denies everything else
+type Policy struct {
+	Rules []Rule `yaml:"rules" json:"rules"`
+}
+
+// Load reads a policy from a YAML file
+func Load(filePath string) (*Policy, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	policy, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", filePath, err)
+	}
+
+	return policy, nil
+}
+
+// Parse reads a policy from YAML and validates its rules
+func Parse(data []byte) (*Policy, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	policy := &Policy{}
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Validate checks the rules for missing names and invalid patterns or levels
+func (p *Policy) Validate() error {
+	var errs []error
+
+	for i, rule := range p.Rules {
+		name := rule.Name
+		if name == "" {
+			errs = append(errs, fmt.Errorf("rule %d: name is required", i))
+			name = fmt.Sprintf("%d", i)
+		}
+		for _, pattern := range append(append([]string(nil), rule.Callers...), rule.Repositories...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("rule %s: invalid pattern %q", name, pattern))
+			}
+		}
+		for permission, level := range rule.Permissions {
+			if !ValidLevel(level) {
+				errs = append(errs, fmt.Errorf("rule %s: invalid level %q for permission %s", name, level, permission))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Authorize checks a token request made by the caller in ctx. Denials are
+// returned as *DeniedError.
+func (p *Policy) Authorize(ctx context.Context, installationID string, request *types.InstallationTokenRequest) error {
+	decision := p.Decide(ctx, installationID, request)
+	if !decision.Allowed {
+		return &DeniedError{Decision: decision}
+	}
+	return nil
+}
+
+// Decide evaluates a token request made by the caller in ctx. GitHubAppAuth
+// uses it to record each decision in the audit log.
+func (p *Policy) Decide(ctx context.Context, installationID string, request *types.InstallationTokenRequest) Decision {
+	return p.Evaluate(CallerFromContext(ctx), installationID, request)
+}
+
+// Evaluate decides a token request made by caller
+func (p *Policy) Evaluate(caller, installationID string, request *types.InstallationTokenRequest) Decision {
+	decision := Decision{
+		Time:           time.Now(),
+		Caller:         caller,
+		InstallationID: installationID,
+	}
+	if request != nil {
+		decision.Repositories = request.Repositories
+		decision.RepositoryIDs = request.RepositoryIDs
+		decision.Permissions = request.Permissions
+	}
+
+	applicable := false
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.matchesCaller(caller) {
+			continue
+		}
+
+		reason := rule.check(installationID, request)
+		if reason == "" {
+			decision.Allowed = true
+			decision.Rule = rule.Name
+			decision.Reason = ""
+			return decision
+		}
+
+		// Report why the first applicable rule refused the request
+		if !applicable {
+			applicable = true
+			decision.Rule = rule.Name
+			decision.Reason = reason
+		}
+	}
+
+	if !applicable {
+		decision.Reason = fmt.Sprintf("no rule applies to caller %q", caller)
+	}
+
+	return decision
+}
+
+// matchesCaller reports whether the rule applies to caller
+func (r *Rule) matchesCaller(caller string) bool {
+	if len(r.Callers) == 0 {
+		return true
+	}
+	return caller != "" && matchesAny(r.Callers, caller)
+}
+
+// check returns why the rule does not allow the request, or "" if it does
+func (r *Rule) check(installationID string, request *types.InstallationTokenRequest) string {
+	if len(r.Installations) > 0 && !contains(r.Installations, installationID) {
+		return fmt.Sprintf("installation %s is not allowed", installationID)
+	}
+
+	if request == nil {
+		request = &types.InstallationTokenRequest{}
+	}
+
+	if len(r.Repositories) > 0 || len(r.RepositoryIDs) > 0 {
+		if len(request.Repositories) == 0 && len(request.RepositoryIDs) == 0 {
+			return "the token must be limited to allowed repositories"
+		}
+		for _, repo := range request.Repositories {
+			if !matchesAny(r.Repositories, repo) {
+				return fmt.Sprintf("repository %s is not allowed", repo)
+			}
+		}
+		for _, id := range request.RepositoryIDs {
+			if !containsInt(r.RepositoryIDs, id) {
+				return fmt.Sprintf("repository ID %d is not allowed", id)
+			}
+		}
+	}
+
+	if len(r.Permissions) > 0 {
+		if len(request.Permissions) == 0 {
+			return "the token must be limited to allowed permissions"
+		}
+		for permission, level := range request.Permissions {
+			max, ok := r.Permissions[permission]
+			if !ok {
+				return fmt.Sprintf("permission %s is not allowed", permission)
+			}
+			if !LevelAllows(max, level) {
+				return fmt.Sprintf("%s: %s exceeds the allowed %s", permission, level, max)
+			}
+		}
+	}
+
+	return ""
+}
+
+// DeniedError is returned when the policy refuses a token request
+type DeniedError struct {
+	Decision Decision
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrDenied, e.Decision.Reason)
+}
+
+// Is makes errors.Is(err, ErrDenied) match
+func (e *DeniedError) Is(target error) bool {
+	return target == ErrDenied
+}
+
+type callerKey struct{}
+
+// WithCaller returns a context identifying who is requesting tokens, matched
+// against the callers of policy rules
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the caller set with WithCaller, or ""
+func CallerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether value matches one of the path.Match patterns, ignoring case
+func matchesAny(patterns []string, value string) bool {
+	value = strings.ToLower(value)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), value); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"ghappauth/internal/types"
+)
+
+const testPolicy = `
+rules:
+  - name: ci
+    callers: ["ci-*"]
+    installations: ["42"]
+    repositories: ["app", "lib-*"]
+    permissions:
+      contents: write
+      metadata: read
+  - name: bots
+    callers: [bot]
+    repository_ids: [7]
+  - name: default
+    installations: ["1"]
+`
+
+func TestPolicy_Evaluate(t *testing.T) {
+	policy, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		caller         string
+		installationID string
+		request        *types.InstallationTokenRequest
+		wantAllowed    bool
+		wantRule       string
+		wantReason     string
+	}{
+		{
+			name:           "within limits",
+			caller:         "ci-build",
+			installationID: "42",
+			request:        &types.InstallationTokenRequest{Repositories: []string{"App", "lib-core"}, Permissions: map[string]string{"contents": "read"}},
+			wantAllowed:    true,
+			wantRule:       "ci",
+		},
+		{
+			name:           "permission above maximum",
+			caller:         "ci-build",
+			installationID: "42",
+			request:        &types.InstallationTokenRequest{Repositories: []string{"app"}, Permissions: map[string]string{"contents": "admin"}},
+			wantRule:       "ci",
+			wantReason:     "contents: admin exceeds the allowed write",
+		},
+		{
+			name:           "permission not listed",
+			caller:         "ci-build",
+			installationID: "42",
+			request:        &types.InstallationTokenRequest{Repositories: []string{"app"}, Permissions: map[string]string{"administration": "write"}},
+			wantRule:       "ci",
+			wantReason:     "permission administration is not allowed",
+		},
+		{
+			name:           "unscoped token",
+			caller:         "ci-build",
+			installationID: "42",
+			wantRule:       "ci",
+			wantReason:     "the token must be limited to allowed repositories",
+		},
+		{
+			name:           "repository not listed",
+			caller:         "ci-build",
+			installationID: "42",
+			request:        &types.InstallationTokenRequest{Repositories: []string{"secrets"}, Permissions: map[string]string{"contents": "read"}},
+			wantRule:       "ci",
+			wantReason:     "repository secrets is not allowed",
+		},
+		{
+			name:           "repository IDs",
+			caller:         "bot",
+			installationID: "99",
+			request:        &types.InstallationTokenRequest{RepositoryIDs: []int{7}},
+			wantAllowed:    true,
+			wantRule:       "bots",
+		},
+		{
+			name:           "later rule allows",
+			caller:         "ci-build",
+			installationID: "1",
+			wantAllowed:    true,
+			wantRule:       "default",
+		},
+		{
+			name:           "anonymous caller",
+			installationID: "42",
+			wantRule:       "default",
+			wantReason:     "installation 42 is not allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := policy.Evaluate(tt.caller, tt.installationID, tt.request)
+			if decision.Allowed != tt.wantAllowed || decision.Rule != tt.wantRule || decision.Reason != tt.wantReason {
+				t.Errorf("Evaluate() = allowed %v, rule %q, reason %q; want %v, %q, %q",
+					decision.Allowed, decision.Rule, decision.Reason, tt.wantAllowed, tt.wantRule, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestPolicy_NoApplicableRule(t *testing.T) {
+	policy := &Policy{Rules: []Rule{{Name: "ci", Callers: []string{"ci"}}}}
+
+	decision := policy.Evaluate("other", "42", nil)
+	if decision.Allowed || decision.Rule != "" || decision.Reason != `no rule applies to caller "other"` {
+		t.Errorf("Unexpected decision %+v", decision)
+	}
+}
+
+func TestPolicy_Authorize(t *testing.T) {
+	policy, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	ctx := WithCaller(context.Background(), "ci-build")
+	if err := policy.Authorize(ctx, "1", nil); err != nil {
+		t.Errorf("Authorize() error = %v", err)
+	}
+
+	err = policy.Authorize(ctx, "42", nil)
+	if !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied, got %v", err)
+	}
+	var denied *DeniedError
+	if !errors.As(err, &denied) || denied.Decision.Caller != "ci-build" {
+		t.Errorf("Expected DeniedError for ci-build, got %v", err)
+	}
+
+	if decision := policy.Decide(ctx, "1", nil); !decision.Allowed || decision.Caller != "ci-build" || decision.Rule == "" {
+		t.Errorf("Expected an allow decision for ci-build naming the rule, got %+v", decision)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":    "rules:\n  - name: a\n    caller: [ci]\n",
+		"missing name":     "rules:\n  - callers: [ci]\n",
+		"invalid level":    "rules:\n  - name: a\n    permissions: {contents: all}\n",
+		"invalid pattern":  "rules:\n  - name: a\n    repositories: ['[']\n",
+		"not a rules list": "rules: yes\n",
+	}
+
+	for name, content := range tests {
+		if _, err := Parse([]byte(content)); err == nil {
+			t.Errorf("Parse() should fail for %s", name)
+		}
+	}
+}
+
+func TestLevelAllows(t *testing.T) {
+	tests := []struct {
+		max, level string
+		want       bool
+	}{
+		{"write", "read", true},
+		{"write", "write", true},
+		{"write", "admin", false},
+		{"read", "write", false},
+		{"admin", "bogus", false},
+	}
+
+	for _, tt := range tests {
+		if got := LevelAllows(tt.max, tt.level); got != tt.want {
+			t.Errorf("LevelAllows(%q, %q) = %v, want %v", tt.max, tt.level, got, tt.want)
+		}
+	}
+}
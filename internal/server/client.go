@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"ghappauth/internal/types"
+)
+
+// ServerError is an unsuccessful response from the token server
+type ServerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("token server error: %s (status: %d)", e.Message, e.StatusCode)
+}
+
+// Is reports a 403 response as ErrDenied
+func (e *ServerError) Is(target error) bool {
+	return target == ErrDenied && e.StatusCode == http.StatusForbidden
+}
+
+// Client requests tokens from a token server over its Unix socket
+type Client struct {
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the server listening on socketPath
+func NewClient(socketPath string) *Client {
+	var dialer net.Dialer
+	return &Client{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Token requests a token from the server
+func (c *Client) Token(ctx context.Context, request *TokenRequest) (*types.GitHubAppToken, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode token request: %w", err)
+	}
+
+	// The host is ignored, requests always go to the socket
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://ghappauth/v1/token", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach token server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			errResp.Error = http.StatusText(resp.StatusCode)
+		}
+		return nil, &ServerError{StatusCode: resp.StatusCode, Message: errResp.Error}
+	}
+
+	var token types.GitHubAppToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"ghappauth/internal/policy"
+	"ghappauth/internal/types"
+)
+
+// ClientPolicy restricts which tokens a caller of the token server may get.
+// Its limits are checked as a policy.Rule, so they mean exactly what they
+// mean in a token policy. Empty restriction fields allow anything for that
+// dimension.
+type ClientPolicy struct {
+	// Name identifies the policy in errors and logs
+	Name string `yaml:"name"`
+	// UIDs and Users select callers by the Unix user connecting to the
+	// socket. A policy with neither applies to every caller.
+	UIDs  []int    `yaml:"uids,omitempty"`
+	Users []string `yaml:"users,omitempty"`
+	// Installations lists the installation IDs the caller may use
+	Installations []string `yaml:"installations,omitempty"`
+	// Repositories lists owner/name patterns (path.Match syntax, e.g.
+	// "octo/*") the caller may request. When set, every request must be
+	// limited to matching repositories.
+	Repositories []string `yaml:"repositories,omitempty"`
+	// Permissions is the most the caller may request for each permission.
+	// When set, requests must list their permissions and stay within these.
+	Permissions map[string]string `yaml:"permissions,omitempty"`
+}
+
+// clientsFile is the on-disk format of the client policies
+type clientsFile struct {
+	Clients []ClientPolicy `yaml:"clients"`
+}
+
+// ErrDenied is returned when a client policy, or the token policy of the
+// App, does not allow a token request
+var ErrDenied = policy.ErrDenied
+
+// LoadClientPolicies reads client policies from a YAML file
+func LoadClientPolicies(filePath string) ([]ClientPolicy, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client policies: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	var file clientsFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse client policies %s: %w", filePath, err)
+	}
+
+	rules := &policy.Policy{}
+	for i, client := range file.Clients {
+		if client.Name == "" {
+			return nil, fmt.Errorf("client policy %d: name is required", i)
+		}
+		for _, pattern := range client.Repositories {
+			if !strings.Contains(pattern, "/") {
+				return nil, fmt.Errorf("client policy %s: invalid repository pattern %q, expected owner/name", client.Name, pattern)
+			}
+		}
+		rules.Rules = append(rules.Rules, client.rule())
+	}
+
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid client policies %s: %w", filePath, err)
+	}
+
+	return file.Clients, nil
+}
+
+// OwnerPolicy allows only the user running the server to request tokens
+func OwnerPolicy() []ClientPolicy {
+	return []ClientPolicy{{Name: "owner", UIDs: []int{os.Getuid()}}}
+}
+
+// matches reports whether the policy applies to the caller
+func (p *ClientPolicy) matches(peer *Peer) bool {
+	if len(p.UIDs) == 0 && len(p.Users) == 0 {
+		return true
+	}
+	if peer == nil {
+		return false
+	}
+
+	for _, uid := range p.UIDs {
+		if uid == peer.UID {
+			return true
+		}
+	}
+
+	if len(p.Users) > 0 {
+		u, err := user.LookupId(strconv.Itoa(peer.UID))
+		if err != nil {
+			return false
+		}
+		for _, name := range p.Users {
+			if name == u.Username {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// rule returns the limits of the client policy as a token policy rule.
+// Repository patterns keep their owner, so requests are checked with owner/name.
+func (p *ClientPolicy) rule() policy.Rule {
+	return policy.Rule{
+		Name:          p.Name,
+		Installations: p.Installations,
+		Repositories:  p.Repositories,
+		Permissions:   p.Permissions,
+	}
+}
+
+// authorize checks the request against the policy
+func (p *ClientPolicy) authorize(installationID string, request *TokenRequest) error {
+	rules := &policy.Policy{Rules: []policy.Rule{p.rule()}}
+	decision := rules.Evaluate(p.Name, installationID, &types.InstallationTokenRequest{
+		Repositories: request.Repositories,
+		Permissions:  request.Permissions,
+	})
+	if !decision.Allowed {
+		return fmt.Errorf("client %s: %w", p.Name, &policy.DeniedError{Decision: decision})
+	}
+
+	return nil
+}
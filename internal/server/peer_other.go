@@ -0,0 +1,15 @@
+//go:build !linux
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// peerCredentials is only implemented on Linux; elsewhere callers stay
+// anonymous and only policies without uids or users apply to them
+func peerCredentials(conn *net.UnixConn) (*Peer, error) {
+	return nil, fmt.Errorf("peer credentials are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,376 @@
+// Package server holds an App's private key and serves installation tokens
+// to local processes over a Unix socket, so build tools never see the key.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ghappauth/internal/audit"
+	"ghappauth/internal/auth"
+	"ghappauth/internal/policy"
+	"ghappauth/internal/types"
+)
+
+// maxRequestBytes bounds the size of a token request body
+const maxRequestBytes = 1 << 20
+
+// shutdownTimeout is how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 5 * time.Second
+
+// installationTTL is how long an owner's installation is cached, since an App
+// can be uninstalled from an account and installed again
+const installationTTL = time.Hour
+
+// TokenRequest is the body of POST /v1/token
+type TokenRequest struct {
+	// InstallationID selects the installation. It can be omitted when
+	// Repositories is set, or to use the server's configured installation.
+	InstallationID string `json:"installation_id,omitempty"`
+	// Repositories limits the token to repositories given as owner/name,
+	// all belonging to the same account
+	Repositories []string `json:"repositories,omitempty"`
+	// Permissions limits the token to the given permission levels
+	Permissions map[string]string `json:"permissions,omitempty"`
+}
+
+// errorResponse is the body of unsuccessful responses
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Peer identifies the process that connected to the socket
+type Peer struct {
+	UID int
+	GID int
+	PID int
+}
+
+type peerKey struct{}
+
+// PeerFromContext returns the caller of a request served over a Unix socket,
+// when the platform can identify it
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	peer, ok := ctx.Value(peerKey{}).(*Peer)
+	return peer, ok
+}
+
+// requestError is a client error reported with a specific HTTP status
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// Server mints installation tokens for local callers according to their
+// client policies
+type Server struct {
+	auth    *auth.GitHubAppAuth
+	tokens  *auth.TokenManager
+	clients []ClientPolicy
+
+	mutex         sync.Mutex
+	installations map[string]cachedInstallation // lowercase owner -> installation
+}
+
+// cachedInstallation is the installation of an owner looked up from GitHub
+type cachedInstallation struct {
+	id        string
+	expiresAt time.Time
+}
+
+// New creates a token server. Each request is checked against the first
+// client policy matching the caller; callers without a policy are refused.
+func New(githubAuth *auth.GitHubAppAuth, tokens *auth.TokenManager, clients []ClientPolicy) *Server {
+	return &Server{
+		auth:          githubAuth,
+		tokens:        tokens,
+		clients:       clients,
+		installations: make(map[string]cachedInstallation),
+	}
+}
+
+// DefaultSocketPath returns $XDG_RUNTIME_DIR/ghappauth.sock, or a socket in a
+// per-user directory under the temporary directory when XDG_RUNTIME_DIR is not set
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "ghappauth.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("ghappauth-%d", os.Getuid()), "ghappauth.sock")
+}
+
+// Listen creates a Unix socket at socketPath restricted to mode, replacing a
+// stale socket left by a previous server. A missing parent directory is
+// created with mode 0700. The socket is created without any access for group
+// or others, so it is never reachable by other users before mode is applied,
+// and Listen refuses directories where another user could replace it.
+func Listen(socketPath string, mode os.FileMode) (net.Listener, error) {
+	dir := filepath.Dir(socketPath)
+	if err := os.Mkdir(dir, 0700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := checkSocketDir(dir); err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		if uid, ok := fileOwner(info); ok && uid != os.Getuid() {
+			return nil, fmt.Errorf("%s is owned by another user", socketPath)
+		}
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := listenUnix(socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+
+	if err := os.Chmod(socketPath, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	return listener, nil
+}
+
+// checkSocketDir refuses a socket directory that another user owns, or that
+// others can write to without the sticky bit, since they could swap the socket
+// for their own
+func checkSocketDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to check socket directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	if uid, ok := fileOwner(info); ok && uid != os.Getuid() && uid != 0 {
+		return fmt.Errorf("socket directory %s is owned by another user", dir)
+	}
+	if info.Mode().Perm()&0022 != 0 && info.Mode()&os.ModeSticky == 0 {
+		return fmt.Errorf("socket directory %s is writable by other users", dir)
+	}
+
+	return nil
+}
+
+// Serve serves requests on listener until ctx is canceled
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			if unixConn, ok := conn.(*net.UnixConn); ok {
+				if peer, err := peerCredentials(unixConn); err == nil {
+					ctx = context.WithValue(ctx, peerKey{}, peer)
+				}
+			}
+			return ctx
+		},
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Handler returns the HTTP API of the server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/token", s.handleToken)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	return mux
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, &requestError{http.StatusMethodNotAllowed, "method not allowed"})
+		return
+	}
+
+	var request TokenRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, &requestError{http.StatusBadRequest, fmt.Sprintf("invalid token request: %v", err)})
+		return
+	}
+
+	token, err := s.issue(r.Context(), &request)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, token)
+}
+
+// issue checks the request against the caller's policy and returns a token
+func (s *Server) issue(ctx context.Context, request *TokenRequest) (*types.GitHubAppToken, error) {
+	peer, _ := PeerFromContext(ctx)
+	client := s.policyFor(peer)
+	if client == nil {
+		return nil, fmt.Errorf("%w: no client policy for %s", ErrDenied, describePeer(peer))
+	}
+
+	installationID, err := s.resolveInstallation(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.authorize(installationID, request); err != nil {
+		return nil, err
+	}
+
+	// The client name is the caller matched by the App's token policy
+	ctx = policy.WithCaller(ctx, client.Name)
+	if peer != nil {
+		ctx = audit.WithLabels(ctx, map[string]string{
+			"uid": strconv.Itoa(peer.UID),
+			"pid": strconv.Itoa(peer.PID),
+		})
+	}
+	return s.tokens.GetScopedToken(ctx, installationID, installationTokenRequest(request))
+}
+
+// policyFor returns the first client policy matching the caller
+func (s *Server) policyFor(peer *Peer) *ClientPolicy {
+	for i := range s.clients {
+		if s.clients[i].matches(peer) {
+			return &s.clients[i]
+		}
+	}
+	return nil
+}
+
+// resolveInstallation returns the installation for the requested
+// repositories, the requested installation, or the configured one
+func (s *Server) resolveInstallation(ctx context.Context, request *TokenRequest) (string, error) {
+	if len(request.Repositories) == 0 {
+		if request.InstallationID != "" {
+			return request.InstallationID, nil
+		}
+		if id := s.auth.InstallationID(); id != "" {
+			return id, nil
+		}
+		return "", &requestError{http.StatusBadRequest, "installation_id or repositories is required"}
+	}
+
+	var owner, firstRepo string
+	for _, repository := range request.Repositories {
+		repoOwner, repo, ok := strings.Cut(repository, "/")
+		if !ok || repoOwner == "" || repo == "" || strings.Contains(repo, "/") {
+			return "", &requestError{http.StatusBadRequest, fmt.Sprintf("invalid repository %q, expected owner/name", repository)}
+		}
+		if owner == "" {
+			owner, firstRepo = repoOwner, repo
+		} else if !strings.EqualFold(owner, repoOwner) {
+			return "", &requestError{http.StatusBadRequest, "all repositories must belong to the same owner"}
+		}
+	}
+
+	key := strings.ToLower(owner)
+	s.mutex.Lock()
+	cached, ok := s.installations[key]
+	s.mutex.Unlock()
+
+	id := cached.id
+	if !ok || !time.Now().Before(cached.expiresAt) {
+		installation, err := s.auth.GetRepositoryInstallation(ctx, owner, firstRepo)
+		if err != nil {
+			return "", err
+		}
+		id = strconv.Itoa(installation.ID)
+
+		s.mutex.Lock()
+		s.installations[key] = cachedInstallation{id: id, expiresAt: time.Now().Add(installationTTL)}
+		s.mutex.Unlock()
+	}
+
+	if request.InstallationID != "" && request.InstallationID != id {
+		return "", &requestError{http.StatusBadRequest, fmt.Sprintf("repositories of %s belong to installation %s, not %s", owner, id, request.InstallationID)}
+	}
+
+	return id, nil
+}
+
+// installationTokenRequest converts a server request into the GitHub API
+// request, which takes repository names without the owner
+func installationTokenRequest(request *TokenRequest) *types.InstallationTokenRequest {
+	if len(request.Repositories) == 0 && len(request.Permissions) == 0 {
+		return nil
+	}
+
+	tokenRequest := &types.InstallationTokenRequest{Permissions: request.Permissions}
+	for _, repository := range request.Repositories {
+		_, repo, _ := strings.Cut(repository, "/")
+		tokenRequest.Repositories = append(tokenRequest.Repositories, repo)
+	}
+
+	return tokenRequest
+}
+
+func describePeer(peer *Peer) string {
+	if peer == nil {
+		return "unidentified caller"
+	}
+	return fmt.Sprintf("uid %d", peer.UID)
+}
+
+// writeError reports err with a status matching its cause
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+
+	var reqErr *requestError
+	switch {
+	case errors.As(err, &reqErr):
+		status = reqErr.status
+	case errors.Is(err, ErrDenied):
+		status = http.StatusForbidden
+	}
+
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,386 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ghappauth/internal/auth"
+	"ghappauth/internal/types"
+)
+
+// newGitHubServer fakes a GitHub Enterprise Server with the App installed on
+// the octo account as installation 42, echoing the requested scope
+func newGitHubServer(t *testing.T, mints *int32) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/octo/app/installation":
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"id": 42}`))
+		case r.Method == "POST" && (r.URL.Path == "/app/installations/42/access_tokens" || r.URL.Path == "/app/installations/7/access_tokens"):
+			var request types.InstallationTokenRequest
+			if r.ContentLength > 0 {
+				json.NewDecoder(r.Body).Decode(&request)
+			}
+			n := atomic.AddInt32(mints, 1)
+			repos := make([]types.Repository, len(request.Repositories))
+			for i, name := range request.Repositories {
+				repos[i] = types.Repository{Name: name}
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(types.GitHubAppToken{
+				Token:        fmt.Sprintf("ghs_%d", n),
+				ExpiresAt:    time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+				Permissions:  request.Permissions,
+				Repositories: repos,
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newTestServer(t *testing.T, baseURL string, clients []ClientPolicy) *Server {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	githubAuth, err := auth.NewAppAuth(&types.GitHubAppConfig{
+		AppID:      "12345",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		BaseURL:    baseURL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create auth: %v", err)
+	}
+
+	return New(githubAuth, auth.NewTokenManager(githubAuth, 0), clients)
+}
+
+func TestServer_Socket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on Linux")
+	}
+
+	var mints int32
+	github := newGitHubServer(t, &mints)
+
+	dir, err := os.MkdirTemp("", "ghappauth")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "s.sock")
+
+	listener, err := Listen(socketPath, 0600)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	info, err := os.Stat(socketPath)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected socket mode 0600, got %v (%v)", info.Mode().Perm(), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- newTestServer(t, github.URL, OwnerPolicy()).Serve(ctx, listener) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() error = %v", err)
+		}
+	}()
+
+	client := NewClient(socketPath)
+	request := &TokenRequest{Repositories: []string{"octo/app"}, Permissions: map[string]string{"contents": "read"}}
+
+	token, err := client.Token(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token.Token != "ghs_1" || token.Permissions["contents"] != "read" || len(token.Repositories) != 1 || token.Repositories[0].Name != "app" {
+		t.Errorf("Unexpected token %+v", token)
+	}
+
+	// The same scope is served from the cache
+	if token, err := client.Token(context.Background(), request); err != nil || token.Token != "ghs_1" {
+		t.Errorf("Expected cached token, got %v, %v", token, err)
+	}
+	if atomic.LoadInt32(&mints) != 1 {
+		t.Errorf("Expected 1 token to be minted, got %d", atomic.LoadInt32(&mints))
+	}
+
+	if _, err := Listen(socketPath, 0600); err == nil {
+		t.Error("Listen() should fail while another server uses the socket")
+	}
+}
+
+func TestListen_SocketDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("directory permissions are only checked on Unix")
+	}
+
+	tmp, err := os.MkdirTemp("", "ghappauth")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Without XDG_RUNTIME_DIR the socket goes in a per-user directory that Listen creates
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("TMPDIR", tmp)
+	socketPath := DefaultSocketPath()
+
+	listener, err := Listen(socketPath, 0660)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	listener.Close()
+
+	if info, err := os.Stat(filepath.Dir(socketPath)); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Expected socket directory mode 0700, got %v (%v)", info.Mode().Perm(), err)
+	}
+
+	shared := filepath.Join(tmp, "shared")
+	os.Mkdir(shared, 0700)
+	os.Chmod(shared, 0777)
+	if _, err := Listen(filepath.Join(shared, "s.sock"), 0600); err == nil {
+		t.Error("Listen() should refuse a directory other users can write to")
+	}
+
+	os.Chmod(shared, 0777|os.ModeSticky)
+	listener, err = Listen(filepath.Join(shared, "s.sock"), 0600)
+	if err != nil {
+		t.Fatalf("Listen() in a sticky directory error = %v", err)
+	}
+	listener.Close()
+
+	notSocket := filepath.Join(tmp, "file")
+	os.WriteFile(notSocket, nil, 0600)
+	if _, err := Listen(notSocket, 0600); err == nil {
+		t.Error("Listen() should not replace a file that is not a socket")
+	}
+}
+
+func TestServer_InstallationCacheExpiry(t *testing.T) {
+	var mints int32
+	github := newGitHubServer(t, &mints)
+	srv := newTestServer(t, github.URL, []ClientPolicy{{Name: "anyone"}})
+	request := &TokenRequest{Repositories: []string{"octo/app"}}
+
+	if _, err := srv.resolveInstallation(context.Background(), request); err != nil {
+		t.Fatalf("resolveInstallation() error = %v", err)
+	}
+
+	// An expired lookup is repeated rather than trusted
+	srv.installations["octo"] = cachedInstallation{id: "7", expiresAt: time.Now().Add(-time.Second)}
+	id, err := srv.resolveInstallation(context.Background(), request)
+	if err != nil {
+		t.Fatalf("resolveInstallation() error = %v", err)
+	}
+	if id != "42" {
+		t.Errorf("Expected installation 42 after the cached lookup expired, got %s", id)
+	}
+	if cached := srv.installations["octo"]; !cached.expiresAt.After(time.Now()) {
+		t.Error("Expected the new lookup to be cached")
+	}
+}
+
+func TestServer_Policy(t *testing.T) {
+	var mints int32
+	github := newGitHubServer(t, &mints)
+
+	srv := newTestServer(t, github.URL, []ClientPolicy{
+		{Name: "builder", UIDs: []int{1001}, Installations: []string{"42"}, Repositories: []string{"octo/*"}, Permissions: map[string]string{"contents": "write", "metadata": "read"}},
+		{Name: "anyone", Installations: []string{"7"}},
+	})
+
+	tests := []struct {
+		name       string
+		peer       *Peer
+		request    TokenRequest
+		wantStatus int
+		wantPerms  map[string]string
+	}{
+		{
+			name:       "permissions required",
+			peer:       &Peer{UID: 1001},
+			request:    TokenRequest{Repositories: []string{"octo/app"}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "lower permission",
+			peer:       &Peer{UID: 1001},
+			request:    TokenRequest{Repositories: []string{"Octo/App"}, Permissions: map[string]string{"contents": "read"}},
+			wantStatus: http.StatusOK,
+			wantPerms:  map[string]string{"contents": "read"},
+		},
+		{
+			name:       "higher permission",
+			peer:       &Peer{UID: 1001},
+			request:    TokenRequest{Repositories: []string{"octo/app"}, Permissions: map[string]string{"contents": "admin"}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "unlisted permission",
+			peer:       &Peer{UID: 1001},
+			request:    TokenRequest{Repositories: []string{"octo/app"}, Permissions: map[string]string{"administration": "read"}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "repositories required",
+			peer:       &Peer{UID: 1001},
+			request:    TokenRequest{InstallationID: "42"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "mixed owners",
+			peer:       &Peer{UID: 1001},
+			request:    TokenRequest{Repositories: []string{"octo/app", "other/app"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "installation mismatch",
+			peer:       &Peer{UID: 1001},
+			request:    TokenRequest{InstallationID: "7", Repositories: []string{"octo/app"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "fallback policy",
+			peer:       &Peer{UID: 2000},
+			request:    TokenRequest{InstallationID: "7"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "fallback policy other installation",
+			request:    TokenRequest{InstallationID: "42"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "no installation",
+			request:    TokenRequest{},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.request)
+			req := httptest.NewRequest(http.MethodPost, "/v1/token", bytes.NewReader(body))
+			if tt.peer != nil {
+				req = req.WithContext(context.WithValue(req.Context(), peerKey{}, tt.peer))
+			}
+			rec := httptest.NewRecorder()
+
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantPerms == nil {
+				return
+			}
+
+			var token types.GitHubAppToken
+			if err := json.Unmarshal(rec.Body.Bytes(), &token); err != nil {
+				t.Fatalf("Failed to decode token: %v", err)
+			}
+			if fmt.Sprint(token.Permissions) != fmt.Sprint(tt.wantPerms) {
+				t.Errorf("Expected permissions %v, got %v", tt.wantPerms, token.Permissions)
+			}
+		})
+	}
+}
+
+func TestServer_NoPolicy(t *testing.T) {
+	var mints int32
+	github := newGitHubServer(t, &mints)
+	srv := newTestServer(t, github.URL, []ClientPolicy{{Name: "builder", UIDs: []int{1001}}})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/token", bytes.NewReader([]byte(`{"installation_id": "42"}`)))
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected unidentified callers to be refused, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/token", bytes.NewReader([]byte(`{"installation": "42"}`)))
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown fields to be rejected, got %d", rec.Code)
+	}
+
+	if atomic.LoadInt32(&mints) != 0 {
+		t.Error("No token should be minted")
+	}
+}
+
+func TestServerError_Is(t *testing.T) {
+	if !errors.Is(&ServerError{StatusCode: http.StatusForbidden}, ErrDenied) {
+		t.Error("403 responses should match ErrDenied")
+	}
+	if errors.Is(&ServerError{StatusCode: http.StatusBadGateway}, ErrDenied) {
+		t.Error("502 responses should not match ErrDenied")
+	}
+}
+
+func TestLoadClientPolicies(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "clients.yaml")
+	os.WriteFile(valid, []byte(`clients:
+  - name: builder
+    users: [builder]
+    installations: ["42"]
+    repositories: ["octo/*"]
+    permissions:
+      contents: read
+`), 0600)
+
+	clients, err := LoadClientPolicies(valid)
+	if err != nil {
+		t.Fatalf("LoadClientPolicies() error = %v", err)
+	}
+	if len(clients) != 1 || clients[0].Name != "builder" || clients[0].Permissions["contents"] != "read" {
+		t.Errorf("Unexpected policies %+v", clients)
+	}
+
+	for name, content := range map[string]string{
+		"unknown field":  "clients:\n  - name: a\n    uid: 1\n",
+		"missing name":   "clients:\n  - uids: [1]\n",
+		"bad level":      "clients:\n  - name: a\n    permissions: {contents: all}\n",
+		"bad repository": "clients:\n  - name: a\n    repositories: [app]\n",
+	} {
+		path := filepath.Join(dir, "invalid.yaml")
+		os.WriteFile(path, []byte(content), 0600)
+		if _, err := LoadClientPolicies(path); err == nil {
+			t.Errorf("LoadClientPolicies() should fail for %s", name)
+		}
+	}
+}
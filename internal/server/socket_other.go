@@ -0,0 +1,18 @@
+//go:build !unix
+
+package server
+
+import (
+	"net"
+	"os"
+)
+
+// listenUnix creates the socket; only Unix systems restrict it while it is created
+func listenUnix(socketPath string) (net.Listener, error) {
+	return net.Listen("unix", socketPath)
+}
+
+// fileOwner is only implemented on Unix systems, where files have a uid
+func fileOwner(info os.FileInfo) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package server
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenUnix creates the socket with a umask that leaves it accessible only
+// to the current user until Listen applies the requested mode. The umask is
+// process-wide, so servers listen before starting other work.
+func listenUnix(socketPath string) (net.Listener, error) {
+	previous := syscall.Umask(0177)
+	defer syscall.Umask(previous)
+
+	return net.Listen("unix", socketPath)
+}
+
+// fileOwner returns the uid owning the file described by info
+func fileOwner(info os.FileInfo) (int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(stat.Uid), true
+}
@@ -4,10 +4,18 @@ import "time"
 
 // GitHubAppConfig holds the configuration for a GitHub App
 type GitHubAppConfig struct {
-	AppID          string `json:"app_id"`
-	PrivateKey     string `json:"private_key"`
-	InstallationID string `json:"installation_id,omitempty"`
-	BaseURL        string `json:"base_url,omitempty"`
+	AppID      string `json:"app_id" yaml:"app_id"`
+	PrivateKey string `json:"private_key" yaml:"private_key"`
+	// PrivateKeyPassphrase decrypts PrivateKey when it is an encrypted PKCS#8 key
+	PrivateKeyPassphrase string `json:"private_key_passphrase,omitempty" yaml:"private_key_passphrase,omitempty"`
+	// FallbackPrivateKeys are tried in order when GitHub rejects PrivateKey,
+	// allowing a new key to be rolled out before the old one is deleted
+	FallbackPrivateKeys []string `json:"fallback_private_keys,omitempty" yaml:"fallback_private_keys,omitempty"`
+	InstallationID      string   `json:"installation_id,omitempty" yaml:"installation_id,omitempty"`
+	BaseURL             string   `json:"base_url,omitempty" yaml:"base_url,omitempty"`
+	// Deployment is "ghes" when BaseURL is the web URL of a GitHub Enterprise
+	// Server host rather than its API root. It is normally left empty.
+	Deployment string `json:"deployment,omitempty" yaml:"deployment,omitempty"`
 }
 
 // GitHubAppToken represents an installation access token
@@ -85,8 +93,15 @@ type GitHubAPIError struct {
 	} `json:"errors,omitempty"`
 }
 
+// GitHubMeta represents the response from the meta endpoint
+type GitHubMeta struct {
+	// InstalledVersion is only reported by GitHub Enterprise Server
+	InstalledVersion string `json:"installed_version,omitempty"`
+}
+
 // InstallationTokenRequest represents the request body for creating an installation token
 type InstallationTokenRequest struct {
+	Repositories  []string `json:"repositories,omitempty"`
 	RepositoryIDs []int  `json:"repository_ids,omitempty"`
 	Permissions   map[string]string `json:"permissions,omitempty"`
 }